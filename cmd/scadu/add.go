@@ -2,17 +2,23 @@ package main
 
 import (
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/suderio/scadufax/pkg/crypt"
 	"github.com/suderio/scadufax/pkg/gitops"
+	"github.com/suderio/scadufax/pkg/processor"
 )
 
-var addWithEdit bool
+var (
+	addWithEdit   bool
+	addAsTemplate bool
+	addEncrypt    bool
+	addAlternate  string
+)
 
 var addCmd = &cobra.Command{
 	Use:   "add [file]...",
@@ -31,6 +37,12 @@ var addCmd = &cobra.Command{
 			homeDir, _ = os.UserHomeDir()
 		}
 
+		// Hooks may stop the command before anything changes
+		homePaths := absPaths(args)
+		if err := runHook("pre_add", homePaths, ""); err != nil {
+			return err
+		}
+
 		// 2. Ensure Main Branch
 		fmt.Println("Switching to branch main...")
 		if err := gitops.Checkout(localDir, "main"); err != nil {
@@ -65,19 +77,59 @@ var addCmd = &cobra.Command{
 				return fmt.Errorf("invalid path %s", arg)
 			}
 
-			repoPath := filepath.Join(localDir, rel)
+			// Validate NOT in Repo (neither as plain file nor as template,
+			// nor, unless adding another one, as an alternate)
+			if addAlternate != "" {
+				rel += processor.AltSep + addAlternate
+				// A condition no machine matches would never be installed
+				_, conds, _ := processor.ParseAlternate(filepath.ToSlash(rel))
+				if err := processor.CheckConditions(conds); err != nil {
+					return err
+				}
+			} else if variants := siblingAlternates(localDir, rel).Variants(filepath.ToSlash(rel)); len(variants) > 0 {
+				return fmt.Errorf("file %s already exists in repo as %s. Use 'scadu edit' to modify it", rel, filepath.FromSlash(variants[0]))
+			}
+			suffix := templateSuffix()
+			candidates := []string{rel}
+			if suffix != "" {
+				candidates = append(candidates, rel+suffix)
+			}
+			for _, candidate := range candidates {
+				candidatePath := filepath.Join(localDir, candidate)
+				if _, err := os.Stat(candidatePath); err == nil {
+					return fmt.Errorf("file %s already exists in repo. Use 'scadu edit' to modify it", rel)
+				} else if !os.IsNotExist(err) {
+					return fmt.Errorf("failed to check repo path %s: %w", candidatePath, err)
+				}
+			}
 
-			// Validate NOT in Repo
-			if _, err := os.Stat(repoPath); err == nil {
-				return fmt.Errorf("file %s already exists in repo. Use 'scadu edit' to modify it", rel)
-			} else if !os.IsNotExist(err) {
-				return fmt.Errorf("failed to check repo path %s: %w", repoPath, err)
+			if addAsTemplate {
+				if suffix == "" {
+					return fmt.Errorf("--template requires scadufax.template_suffix to be set")
+				}
+				rel += suffix
 			}
+			repoPath := filepath.Join(localDir, rel)
 
-			// Copy Home -> Repo
-			fmt.Printf("Adding %s to repo...\n", rel)
-			if err := copyFile(absPath, repoPath); err != nil {
-				return fmt.Errorf("failed to copy %s to repo: %w", rel, err)
+			// Copy Home -> Repo; a link is stored as a link, not what it points to
+			if isSymlink(absPath) {
+				if addEncrypt || addAsTemplate || addWithEdit {
+					return fmt.Errorf("%s is a symbolic link: it cannot be encrypted, templated or edited", arg)
+				}
+				fmt.Printf("Adding %s to repo (symlink)...\n", rel)
+				if err := copyLink(absPath, repoPath); err != nil {
+					return fmt.Errorf("failed to copy %s to repo: %w", rel, err)
+				}
+			} else if addEncrypt {
+				fmt.Printf("Adding %s to repo (encrypted)...\n", rel)
+				if err := encryptFile(absPath, repoPath); err != nil {
+					return fmt.Errorf("failed to encrypt %s to repo: %w", rel, err)
+				}
+			} else {
+				fmt.Printf("Adding %s to repo...\n", rel)
+				if err := copyFile(absPath, repoPath); err != nil {
+					return fmt.Errorf("failed to copy %s to repo: %w", rel, err)
+				}
 			}
 
 			repoFiles = append(repoFiles, repoPath)
@@ -88,55 +140,60 @@ var addCmd = &cobra.Command{
 		if addWithEdit {
 			// Use PerformEdit
 			// PerformEdit handles editing, reifying, installing, committing
-			return PerformEdit(repoFiles, localDir, homeDir)
+			commitID, err := PerformEdit(repoFiles, localDir, homeDir)
+			if err != nil {
+				return err
+			}
+			postHook("post_add", homePaths, commitID)
+			return nil
 		}
 
 		// 5. Direct Commit
+		var commitID string
 		for _, rel := range relPaths {
 			fmt.Printf("Committing %s...\n", rel)
-			msg := GenerateCommitMessage(fmt.Sprintf("Add %s via scadu add", rel))
-			if err := gitops.CommitFile(localDir, rel, msg); err != nil {
+			id, err := commitFile(localDir, rel, fmt.Sprintf("Add %s via scadu add", rel))
+			if err != nil {
 				return fmt.Errorf("failed to commit %s: %w", rel, err)
 			}
+			commitID = id
 		}
 
 		fmt.Println("Done.")
+		postHook("post_add", homePaths, commitID)
 		return nil
 	},
 }
 
 func init() {
 	addCmd.Flags().BoolVar(&addWithEdit, "edit", false, "Edit the files after adding")
+	addCmd.Flags().BoolVar(&addAsTemplate, "template", false, "Store the files as templates (adds the template suffix)")
+	addCmd.Flags().BoolVar(&addEncrypt, "encrypt", false, "Store the files encrypted with the local key")
+	addCmd.Flags().StringVar(&addAlternate, "alternate", "", "Store the files as an alternate for the given conditions (e.g. os.linux)")
 	rootCmd.AddCommand(addCmd)
 }
 
-func copyFile(src, dst string) error {
-	// Ensure dest dir exists
-	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+// encryptFile stores src encrypted at dst, generating the key on first use.
+func encryptFile(src, dst string) error {
+	key, err := crypt.LoadOrCreateKey(keyPath())
+	if err != nil {
 		return err
 	}
-
-	sourceFile, err := os.Open(src)
+	plain, err := os.ReadFile(src)
 	if err != nil {
 		return err
 	}
-	defer sourceFile.Close()
-
-	destFile, err := os.Create(dst)
+	sealed, err := crypt.Encrypt(key, plain)
 	if err != nil {
 		return err
 	}
-	defer destFile.Close()
-
-	if _, err := io.Copy(destFile, sourceFile); err != nil {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
 		return err
 	}
+	return os.WriteFile(dst, sealed, 0600)
+}
 
-	// Copy mode
-	info, err := os.Stat(src)
-	if err == nil {
-		os.Chmod(dst, info.Mode())
-	}
-
-	return nil
+func copyFile(src, dst string) error {
+	// Streams the file, so large and binary files are copied byte-for-byte
+	return processor.CopyFile(src, dst)
 }
@@ -9,6 +9,7 @@ import (
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
@@ -156,4 +157,201 @@ echo "# Added by mock" >> "$1"
 		require.NoError(t, err)
 		assert.Contains(t, string(homeContent), "# Added by mock")
 	})
+
+	t.Run("Add As Template", func(t *testing.T) {
+		viper.Set("scadufax.template_suffix", ".tmpl")
+		defer viper.Set("scadufax.template_suffix", "")
+
+		fName := ".gitconfig"
+		fPath := filepath.Join(homeDir, fName)
+		err := os.WriteFile(fPath, []byte("[user]"), 0644)
+		require.NoError(t, err)
+
+		cmd := rootCmd
+		defer func() { addAsTemplate = false }()
+		cmd.SetArgs([]string{"add", "--template", fPath})
+		err = cmd.Execute()
+		require.NoError(t, err)
+
+		// Stored with the suffix
+		content, err := os.ReadFile(filepath.Join(localDir, fName+".tmpl"))
+		require.NoError(t, err)
+		assert.Equal(t, "[user]", string(content))
+		_, err = os.Stat(filepath.Join(localDir, fName))
+		assert.True(t, os.IsNotExist(err))
+
+		// Adding it again is rejected, even without --template
+		addAsTemplate = false
+		cmd.SetArgs([]string{"add", fPath})
+		err = cmd.Execute()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "already exists in repo")
+	})
+
+	t.Run("Add Encrypted", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("Skipping shell script mock editor test on Windows")
+		}
+
+		keyFile := filepath.Join(rootDir, "key")
+		viper.Set("scadufax.key_file", keyFile)
+		defer viper.Set("scadufax.key_file", "")
+
+		fName := ".ssh/id_ed25519"
+		fPath := filepath.Join(homeDir, fName)
+		err := os.MkdirAll(filepath.Dir(fPath), 0700)
+		require.NoError(t, err)
+		err = os.WriteFile(fPath, []byte("PRIVATE KEY\n"), 0600)
+		require.NoError(t, err)
+
+		cmd := rootCmd
+		defer func() { addEncrypt = false }()
+		cmd.SetArgs([]string{"add", "--encrypt", fPath})
+		err = cmd.Execute()
+		require.NoError(t, err)
+
+		// Key generated, private
+		info, err := os.Stat(keyFile)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+		// Stored encrypted
+		repoPath := filepath.Join(localDir, fName)
+		raw, err := os.ReadFile(repoPath)
+		require.NoError(t, err)
+		assert.NotContains(t, string(raw), "PRIVATE KEY")
+
+		// Edit works on the plain text and re-encrypts
+		mockEditorPath := filepath.Join(rootDir, "mock_editor_enc.sh")
+		err = os.WriteFile(mockEditorPath, []byte("#!/bin/sh\necho \"COMMENT {{ .scadufax.fork }}\" >> \"$1\"\n"), 0755)
+		require.NoError(t, err)
+		os.Setenv("EDITOR", mockEditorPath)
+		defer os.Unsetenv("EDITOR")
+		viper.Set("scadufax.fork", "box")
+
+		addEncrypt = false
+		cmd.SetArgs([]string{"edit", fPath})
+		err = cmd.Execute()
+		require.NoError(t, err)
+
+		raw, err = os.ReadFile(repoPath)
+		require.NoError(t, err)
+		assert.NotContains(t, string(raw), "COMMENT")
+
+		content, err := os.ReadFile(fPath)
+		require.NoError(t, err)
+		assert.Equal(t, "PRIVATE KEY\nCOMMENT box\n", string(content))
+		info, err = os.Stat(fPath)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+		// Compared by plain text
+		rendered := filepath.Join(rootDir, "rendered")
+		err = os.WriteFile(rendered, []byte("PRIVATE KEY\nCOMMENT {{ .scadufax.fork }}\n"), 0600)
+		require.NoError(t, err)
+		assert.False(t, areFilesDifferent(repoPath, rendered))
+		assert.True(t, areFilesDifferent(repoPath, fPath))
+	})
+
+	t.Run("Add Alternate", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("Skipping shell script mock editor test on Windows")
+		}
+
+		viper.Set("scadufax.fork", "box")
+		fName := ".inputrc"
+		fPath := filepath.Join(homeDir, fName)
+		err := os.WriteFile(fPath, []byte("set bell-style none\n"), 0644)
+		require.NoError(t, err)
+
+		cmd := rootCmd
+		defer func() { addAlternate = "" }()
+		for _, cond := range []string{"fork.box", "fork.other"} {
+			cmd.SetArgs([]string{"add", "--alternate", cond, fPath})
+			err = cmd.Execute()
+			require.NoError(t, err)
+			_, err = os.Stat(filepath.Join(localDir, fName+"##"+cond))
+			require.NoError(t, err)
+		}
+
+		// Conditions no machine matches are refused
+		for _, cond := range []string{"os-linux", "distro.arch", "os.", "fork.box,linux"} {
+			cmd.SetArgs([]string{"add", "--alternate", cond, fPath})
+			err = cmd.Execute()
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "invalid alternate condition")
+			_, err = os.Stat(filepath.Join(localDir, fName+"##"+cond))
+			assert.True(t, os.IsNotExist(err))
+		}
+
+		// The plain name is taken by its alternates
+		addAlternate = ""
+		cmd.SetArgs([]string{"add", fPath})
+		err = cmd.Execute()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "already exists in repo")
+
+		// Edit opens the active variant by default and installs it
+		mockEditorPath := filepath.Join(rootDir, "mock_editor_alt.sh")
+		err = os.WriteFile(mockEditorPath, []byte("#!/bin/sh\necho \"# {{ .sys.fork }}\" >> \"$1\"\n"), 0755)
+		require.NoError(t, err)
+		os.Setenv("EDITOR", mockEditorPath)
+		defer os.Unsetenv("EDITOR")
+
+		cmd.SetArgs([]string{"edit", fPath})
+		output := captureOutput(func() {
+			err = cmd.Execute()
+		})
+		require.NoError(t, err)
+		assert.Contains(t, output, "[1] .inputrc##fork.box (active)")
+
+		content, err := os.ReadFile(filepath.Join(localDir, fName+"##fork.box"))
+		require.NoError(t, err)
+		assert.Equal(t, "set bell-style none\n# {{ .sys.fork }}\n", string(content))
+		content, err = os.ReadFile(fPath)
+		require.NoError(t, err)
+		assert.Equal(t, "set bell-style none\n# box\n", string(content))
+
+		// Editing the variant of another machine only commits it
+		cmd.SetArgs([]string{"edit", filepath.Join(homeDir, fName+"##fork.other")})
+		err = cmd.Execute()
+		require.NoError(t, err)
+		content, err = os.ReadFile(fPath)
+		require.NoError(t, err)
+		assert.Equal(t, "set bell-style none\n# box\n", string(content))
+		_, err = os.Stat(filepath.Join(homeDir, fName+"##fork.other"))
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("Add Symlink", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("Skipping symlink test on Windows")
+		}
+
+		fName := ".vimrc"
+		fPath := filepath.Join(homeDir, fName)
+		require.NoError(t, os.Symlink(".config/vim/vimrc", fPath))
+
+		cmd := rootCmd
+		cmd.SetArgs([]string{"add", "--encrypt", fPath})
+		err := cmd.Execute()
+		addEncrypt = false
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "symbolic link")
+
+		// Stored as a link, not as what it points to, which is missing
+		cmd.SetArgs([]string{"add", fPath})
+		require.NoError(t, cmd.Execute())
+		dest, err := os.Readlink(filepath.Join(localDir, fName))
+		require.NoError(t, err)
+		assert.Equal(t, ".config/vim/vimrc", dest)
+
+		head, err := repo.Head()
+		require.NoError(t, err)
+		commit, err := repo.CommitObject(head.Hash())
+		require.NoError(t, err)
+		file, err := commit.File(fName)
+		require.NoError(t, err)
+		assert.Equal(t, filemode.Symlink, file.Mode)
+	})
 }
@@ -11,7 +11,9 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/suderio/scadufax/pkg/gitops"
+	"github.com/suderio/scadufax/pkg/manifest"
 	"github.com/suderio/scadufax/pkg/processor"
+	"github.com/suderio/scadufax/pkg/state"
 )
 
 var (
@@ -34,138 +36,226 @@ var checkCmd = &cobra.Command{
 			homeDir, _ = os.UserHomeDir()
 		}
 
-		forkName := viper.GetString("scadufax.fork")
-		if forkName == "" {
-			hostname, _ := os.Hostname()
-			forkName = hostname
-		}
+		forkName := resolvedFork()
 
 		// Load ignores
 		ignorePatterns := viper.GetStringSlice("root.ignore")
 
 		// 1. Pull
 		if !checkFlagLocal {
-			fmt.Println("Pulling changes...")
+			fmt.Fprintln(messages(), "Pulling changes...")
 			if err := gitops.Pull(localDir); err != nil {
-				fmt.Printf("Warning: pull failed: %v\n", err)
+				fmt.Fprintf(messages(), "Warning: pull failed: %v\n", err)
 			}
 		}
 
 		// 2. Fork Comparison
-		fmt.Printf("Checking fork branch '%s'...\n", forkName)
+		fmt.Fprintf(messages(), "Checking fork branch '%s'...\n", forkName)
 		if err := gitops.Checkout(localDir, forkName); err != nil {
 			return fmt.Errorf("failed to checkout fork branch %s: %w", forkName, err)
 		}
 
-		fmt.Println("Local Status:")
-		if err := compareDirs(localDir, homeDir, ignorePatterns, checkFlagAll); err != nil {
+		// Files the manifest excludes for this machine are not compared
+		filter, err := fileFilter(localDir)
+		if err != nil {
 			return err
 		}
 
-		// 3. Full Comparison (Main vs Fork)
-		if checkFlagFull {
-			fmt.Println("\nChecking main branch (template status)...")
-			if err := gitops.Checkout(localDir, "main"); err != nil {
-				return fmt.Errorf("failed to checkout main: %w", err)
-			}
-
-			// Reify main to temp
-			// We DO NOT use processor.Reify as it is in-place.
-			// We use ReifyFile to write to tempDir.
-			tempDir, err := os.MkdirTemp("", "scadu-check-full-*")
-			if err != nil {
-				return fmt.Errorf("failed to create temp dir: %w", err)
-			}
-			defer os.RemoveAll(tempDir)
+		// Modes the manifest sets are part of the state of home
+		rules, err := loadManifest(localDir)
+		if err != nil {
+			return err
+		}
 
-			// Secret Strategy: Preserve tags (compare against fork which has secrets preserved)
-			secretFn := func(key string) (string, error) {
-				return fmt.Sprintf("{{ %q | secret }}", key), nil
-			}
+		// What was installed tells which side moved since
+		st, err := loadState()
+		if err != nil {
+			return err
+		}
 
-			data := viper.AllSettings()
+		fmt.Fprintln(messages(), "Local Status:")
+		statuses, err := compareDirs(localDir, homeDir, forkName, ignorePatterns, checkFlagAll, filter, rules, st)
+		if err != nil {
+			return err
+		}
+		if output == outputText {
+			printStatuses(statuses)
+		}
 
-			// Walk main (localDir) and reify to tempDir
-			// Wait, we need to walk localDir content, apply template, write to dest.
-			// processor.ReifyFile(source, dest...)
-			err = filepath.WalkDir(localDir, func(path string, d fs.DirEntry, err error) error {
-				if err != nil {
-					return err
-				}
-				if d.IsDir() {
-					if d.Name() == ".git" {
-						return filepath.SkipDir
-					}
-					return nil
-				}
-				rel, _ := filepath.Rel(localDir, path)
-				destPath := filepath.Join(tempDir, rel)
-				return processor.ReifyFile(path, destPath, data, secretFn)
-			})
+		// 3. Full Comparison (Main vs Fork)
+		if checkFlagFull {
+			fmt.Fprintln(messages(), "\nChecking main branch (template status)...")
+			tempDir, err := reifyMain(localDir, forkName, ignorePatterns)
 			if err != nil {
-				return fmt.Errorf("failed to reify main to temp: %w", err)
-			}
-
-			// Checkout fork again to compare against it
-			if err := gitops.Checkout(localDir, forkName); err != nil {
-				return fmt.Errorf("failed to checkout fork %s: %w", forkName, err)
+				return err
 			}
+			defer os.RemoveAll(tempDir)
 
-			fmt.Println("Template Status (Main vs Fork):")
+			fmt.Fprintln(messages(), "Template Status (Main vs Fork):")
 			// Compare Temp (Desired Fork State) vs Local (Actual Fork State)
 			// Note: We are comparing 'tempDir' (Source) vs 'localDir' (Target)
-			if err := compareDirs(tempDir, localDir, ignorePatterns, checkFlagAll); err != nil {
+			// Git does not keep modes, so they are not compared
+			template, err := compareDirs(tempDir, localDir, "main", ignorePatterns, checkFlagAll, filter, nil, nil)
+			if err != nil {
 				return err
 			}
+			if output == outputText {
+				printStatuses(template)
+			}
+			statuses = append(statuses, template...)
 		}
 
-		return nil
+		return writeRecords(statuses)
 	},
 }
 
-func compareDirs(sourceDir, targetDir string, ignores []string, checkAll bool) error {
-	green := color.New(color.FgGreen).SprintFunc()
-	yellow := color.New(color.FgYellow).SprintFunc()
-	red := color.New(color.FgRed).SprintFunc()
+// reifyMain reifies the main branch of the repository at localDir into a
+// temp dir, as the fork forkName should hold it, and checks the fork out
+// again. Secrets are left as tags, as in the fork. The caller removes the
+// returned dir.
+func reifyMain(localDir, forkName string, ignores []string) (string, error) {
+	if err := gitops.Checkout(localDir, "main"); err != nil {
+		return "", fmt.Errorf("failed to checkout main: %w", err)
+	}
+
+	tempDir, err := os.MkdirTemp("", "scadu-main-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	reified := false
+	defer func() {
+		if !reified {
+			os.RemoveAll(tempDir)
+		}
+	}()
+
+	includes, err := loadIncludes(localDir, forkName)
+	if err != nil {
+		return "", err
+	}
+	key, err := loadKey()
+	if err != nil {
+		return "", err
+	}
+	filter, err := fileFilter(localDir)
+	if err != nil {
+		return "", err
+	}
+	delims, err := manifestDelims(localDir)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = processor.ReifyTree(localDir, tempDir, templateData(), preserveSecret, false,
+		includes,
+		delims,
+		processor.WithTemplateSuffix(templateSuffix()),
+		processor.WithKey(key),
+		processor.WithMachine(machine()),
+		processor.WithFilter(filter),
+		processor.WithIgnore(ignores...),
+		renderCache(),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to reify main to temp: %w", err)
+	}
+
+	if err := gitops.Checkout(localDir, forkName); err != nil {
+		return "", fmt.Errorf("failed to checkout fork %s: %w", forkName, err)
+	}
+	reified = true
+	return tempDir, nil
+}
+
+// compareDirs returns how targetDir differs from sourceDir, which comes
+// from branch. Files rejected by filter are skipped on both sides, and
+// alternates in sourceDir are compared under their base name when selected
+// for this machine. Unless rules is nil, the modes, directories and links
+// it sets are compared too. Unless st is nil, a modified file is told apart
+// as changed in targetDir (M), in sourceDir (U) or in both (C) since it was
+// installed, or as out of sync (O) when st has no record of its install. With checkAll, files only targetDir has are listed as D.
+func compareDirs(sourceDir, targetDir, branch string, ignores []string, checkAll bool, filter processor.Filter, rules *manifest.Manifest, st *state.State) ([]fileStatus, error) {
+	var statuses []fileStatus
+	add := func(status, target, rel, src, dst string) {
+		asLink := src != "" && isSymlink(src)
+		s := fileStatus{Path: target, Status: status, Branch: branch, Target: describe(dst, asLink), rel: rel}
+		if src != "" {
+			s.Source = describe(src, asLink)
+		}
+		statuses = append(statuses, s)
+	}
+
+	alts, err := findAlternates(sourceDir, "")
+	if err != nil {
+		return nil, err
+	}
 
 	// 1. Walk Source
-	err := filepath.WalkDir(sourceDir, func(path string, d fs.DirEntry, err error) error {
+	err = filepath.WalkDir(sourceDir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
+		rel, err := filepath.Rel(sourceDir, path)
+		if err != nil {
+			return err
+		}
+
 		if d.IsDir() {
-			if d.Name() == ".git" {
+			if d.Name() == ".git" || isMetaPath(rel) {
 				return filepath.SkipDir
 			}
 			return nil
 		}
 
-		rel, err := filepath.Rel(sourceDir, path)
-		if err != nil {
-			return err
+		target, ok := alts.Target(filepath.ToSlash(rel))
+		if !ok {
+			return nil
 		}
 
-		if isIgnored(rel, ignores) {
+		if isIgnored(filepath.FromSlash(target), ignores) {
 			return nil
 		}
+		if ok, err := filter(target); err != nil || !ok {
+			return err
+		}
 
-		targetPath := filepath.Join(targetDir, rel)
+		targetPath := filepath.Join(targetDir, filepath.FromSlash(target))
 
 		// Check existence
-		if _, err := os.Stat(targetPath); os.IsNotExist(err) {
-			fmt.Printf("%s\t%s\n", green("N"), rel)
+		if _, err := os.Lstat(targetPath); os.IsNotExist(err) {
+			add("N", target, rel, path, targetPath)
+			return nil
+		}
+
+		// Files the manifest links must link to their reified copy
+		if rules != nil && rules.Symlink(target) && linkDrift(targetPath, reifiedPath(target)) {
+			add("L", target, rel, path, targetPath)
 			return nil
 		}
 
 		// Compare
 		if areFilesDifferent(path, targetPath) {
-			fmt.Printf("%s\t%s\n", yellow("M"), rel)
+			add(driftStatus(st, target, path, targetPath), target, rel, path, targetPath)
+		} else if permDrift(rules, target, targetPath) {
+			add("P", target, rel, path, targetPath)
 		}
 
 		return nil
 	})
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// Directories the manifest lists, which git cannot track when empty
+	if rules != nil {
+		dirs, err := changedDirs(targetDir, rules, filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range dirs {
+			dst := filepath.Join(targetDir, filepath.FromSlash(d.Dir.Path))
+			statuses = append(statuses, fileStatus{Path: d.Dir.Path, Status: d.Status, Branch: branch, Dir: true, Target: describe(dst, false), dir: d.Dir})
+		}
 	}
 
 	// 2. Walk Target (if --all)
@@ -176,36 +266,68 @@ func compareDirs(sourceDir, targetDir string, ignores []string, checkAll bool) e
 			if err != nil {
 				return err
 			}
+			rel, err := filepath.Rel(targetDir, path)
+			if err != nil {
+				return err
+			}
+
 			if d.IsDir() {
 				// Should we skip .git in home too? Probably.
-				if d.Name() == ".git" {
+				if d.Name() == ".git" || isMetaPath(rel) {
 					return filepath.SkipDir
 				}
 				return nil
 			}
 
-			rel, err := filepath.Rel(targetDir, path)
-			if err != nil {
-				return err
-			}
-
 			if isIgnored(rel, ignores) {
 				return nil
 			}
+			if ok, err := filter(filepath.ToSlash(rel)); err != nil || !ok {
+				return err
+			}
 
 			sourcePath := filepath.Join(sourceDir, rel)
+			if _, selected := alts.Selected(filepath.ToSlash(rel)); selected {
+				return nil
+			}
 			if _, err := os.Stat(sourcePath); os.IsNotExist(err) {
-				fmt.Printf("%s\t%s\n", red("D"), rel)
+				add("D", filepath.ToSlash(rel), "", "", path)
 			}
 
 			return nil
 		})
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	return nil
+	return statuses, nil
+}
+
+// printStatuses prints statuses as check does, a colored letter and a path
+// per line.
+func printStatuses(statuses []fileStatus) {
+	green := color.New(color.FgGreen).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+	red := color.New(color.FgRed).SprintFunc()
+	cyan := color.New(color.FgCyan).SprintFunc()
+	magenta := color.New(color.FgMagenta).SprintFunc()
+	blue := color.New(color.FgBlue).SprintFunc()
+	colors := map[string]func(...any) string{
+		"N": green, "M": yellow, "O": yellow, "U": blue, "C": red, "D": red, "P": cyan, "L": magenta,
+	}
+
+	for _, s := range statuses {
+		status := s.Status
+		if c, ok := colors[status]; ok {
+			status = c(status)
+		}
+		path := filepath.FromSlash(s.Path)
+		if s.Dir {
+			path += string(filepath.Separator)
+		}
+		fmt.Printf("%s\t%s\n", status, path)
+	}
 }
 
 func isIgnored(relPath string, patterns []string) bool {
@@ -231,6 +353,42 @@ func isIgnored(relPath string, patterns []string) bool {
 }
 
 func areFilesDifferent(pathA, pathB string) bool {
+	// Links stored in the repository are compared by where they point
+	if isSymlink(pathA) {
+		destA, errA := os.Readlink(pathA)
+		destB, errB := os.Readlink(pathB)
+		return errA != nil || errB != nil || destA != destB
+	}
+
+	// Encrypted and sealed files are compared by their plain text
+	encA, errA := isProtected(pathA)
+	encB, errB := isProtected(pathB)
+	if errA != nil || errB != nil {
+		return true
+	}
+	if encA || encB {
+		cA, _, err := readDecrypted(pathA)
+		if err != nil {
+			return true
+		}
+		cB, _, err := readDecrypted(pathB)
+		if err != nil {
+			return true
+		}
+		return !bytes.Equal(cA, cB)
+	}
+
+	// Binary files may be large: compare them block by block
+	binA, errA := processor.IsBinary(pathA)
+	binB, errB := processor.IsBinary(pathB)
+	if errA != nil || errB != nil {
+		return true
+	}
+	if binA || binB {
+		same, err := processor.SameContent(pathA, pathB)
+		return err != nil || !same
+	}
+
 	cA, err := os.ReadFile(pathA)
 	if err != nil {
 		return true
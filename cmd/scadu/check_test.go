@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
 	"os"
 	"path/filepath"
@@ -14,6 +15,7 @@ import (
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/suderio/scadufax/pkg/state"
 )
 
 func captureOutput(f func()) string {
@@ -46,6 +48,7 @@ func TestCheckCommand_Integration(t *testing.T) {
 	viper.Set("scadufax.home_dir", homeDir)
 	viper.Set("scadufax.fork", "testfork")
 	viper.Set("root.ignore", []string{"ignored.txt"})
+	viper.Set("scadufax.state_dir", filepath.Join(rootDir, "state"))
 
 	// Setup Repo
 	repo, err := git.PlainInit(localDir, false)
@@ -142,7 +145,7 @@ func TestCheckCommand_Integration(t *testing.T) {
 	// Assertions
 	// Check for filenames in output
 	assert.Contains(t, output, "Local Status:")
-	assert.Contains(t, output, "file2.conf")          // M
+	assert.Contains(t, output, "O\tfile2.conf")       // Differs, never installed
 	assert.Contains(t, output, "extra.txt")           // D
 	assert.Contains(t, output, "missing_in_home.txt") // N
 	assert.NotContains(t, output, "file1.txt")        // Clean
@@ -184,4 +187,95 @@ func TestCheckCommand_Integration(t *testing.T) {
 
 	assert.Contains(t, outputFull, "Template Status (Main vs Fork):")
 	assert.Contains(t, outputFull, "file2.conf") // Should be Modified (M) in template status
+
+	// Records of the full check tell the fork from main, whose side is
+	// rendered and compared with the fork
+	defer rootCmd.PersistentFlags().Set("output", "text")
+	outputJSON := captureOutput(func() {
+		cmd := rootCmd
+		cmd.SetArgs([]string{"check", "--local", "--full", "--all=false", "--output", "json"})
+		assert.NoError(t, cmd.Execute())
+	})
+	var records []fileStatus
+	require.NoError(t, json.Unmarshal([]byte(outputJSON), &records), outputJSON)
+
+	var fork, main []fileStatus
+	for _, r := range records {
+		switch r.Branch {
+		case "testfork":
+			fork = append(fork, r)
+		case "main":
+			main = append(main, r)
+		}
+	}
+	require.Len(t, fork, 2)
+	assert.Equal(t, "file2.conf", fork[0].Path)
+	assert.Equal(t, "missing_in_home.txt", fork[1].Path)
+	assert.Equal(t, "N", fork[1].Status)
+	assert.Nil(t, fork[1].Target)
+
+	require.Len(t, main, 1)
+	assert.Equal(t, "file2.conf", main[0].Path)
+	assert.Equal(t, "M", main[0].Status)
+	assert.Equal(t, state.Sum([]byte("val = testvalue changed")), main[0].Source.SHA256)
+	assert.Equal(t, state.Sum([]byte("val = testvalue")), main[0].Target.SHA256)
+	assert.Equal(t, int64(len("val = testvalue")), main[0].Target.Size)
+}
+
+func TestCheckCommand_Perms(t *testing.T) {
+	rootDir := setupTestDir(t)
+	homeDir := filepath.Join(rootDir, "home")
+	localDir := filepath.Join(rootDir, "local")
+	require.NoError(t, os.MkdirAll(filepath.Join(homeDir, ".ssh"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(localDir, ".ssh"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(localDir, ".scadufax"), 0755))
+
+	viper.Reset()
+	viper.Set("scadufax.local_dir", localDir)
+	viper.Set("scadufax.home_dir", homeDir)
+	viper.Set("scadufax.fork", "testfork")
+
+	repo, err := git.PlainInit(localDir, false)
+	require.NoError(t, err)
+	err = repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.ReferenceName("refs/heads/testfork")))
+	require.NoError(t, err)
+
+	rules := "[[file]]\npath = \".ssh/**\"\nperm = \"0600\"\n\n[[dir]]\npath = \".ssh\"\nperm = \"0700\"\n\n[[dir]]\npath = \".gnupg\"\nperm = \"0700\"\n"
+	require.NoError(t, os.WriteFile(filepath.Join(localDir, ".scadufax", "manifest.toml"), []byte(rules), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(localDir, ".ssh", "config"), []byte("Host *\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(localDir, ".bashrc"), []byte("# bash\n"), 0644))
+
+	w, err := repo.Worktree()
+	require.NoError(t, err)
+	_, err = w.Add(".")
+	require.NoError(t, err)
+	_, err = w.Commit("Fork", &git.CommitOptions{
+		Author: &object.Signature{Name: "Test", Email: "test@local", When: time.Now()},
+	})
+	require.NoError(t, err)
+
+	// Same content everywhere, but home has the modes git would give
+	require.NoError(t, os.WriteFile(filepath.Join(homeDir, ".ssh", "config"), []byte("Host *\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(homeDir, ".bashrc"), []byte("# bash\n"), 0644))
+
+	output := captureOutput(func() {
+		rootCmd.SetArgs([]string{"check", "--local", "--full=false"})
+		assert.NoError(t, rootCmd.Execute())
+	})
+	assert.Contains(t, output, "P\t"+filepath.Join(".ssh", "config"))
+	assert.Contains(t, output, "P\t.ssh"+string(filepath.Separator))
+	assert.Contains(t, output, "N\t.gnupg"+string(filepath.Separator))
+	assert.NotContains(t, output, ".bashrc")
+
+	// Once the modes are right, there is nothing to report
+	require.NoError(t, os.Chmod(filepath.Join(homeDir, ".ssh", "config"), 0600))
+	require.NoError(t, os.Chmod(filepath.Join(homeDir, ".ssh"), 0700))
+	require.NoError(t, os.Mkdir(filepath.Join(homeDir, ".gnupg"), 0700))
+
+	output = captureOutput(func() {
+		rootCmd.SetArgs([]string{"check", "--local", "--full=false"})
+		assert.NoError(t, rootCmd.Execute())
+	})
+	assert.NotContains(t, output, "P\t")
+	assert.NotContains(t, output, ".gnupg")
 }
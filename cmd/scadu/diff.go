@@ -0,0 +1,300 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/suderio/scadufax/pkg/gitops"
+	"github.com/suderio/scadufax/pkg/processor"
+)
+
+var (
+	diffFlagFull bool
+	diffFlagTool string
+)
+
+// diffContext is how many unchanged lines surround each change.
+const diffContext = 3
+
+var diffCmd = &cobra.Command{
+	Use:   "diff [path]...",
+	Short: "Show what differs between home and the repository, line by line",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		localDir := viper.GetString("scadufax.local_dir")
+		if localDir == "" {
+			home, _ := os.UserHomeDir()
+			localDir = filepath.Join(home, ".local", "share", "scadufax")
+		}
+		homeDir := viper.GetString("scadufax.home_dir")
+		if homeDir == "" {
+			homeDir, _ = os.UserHomeDir()
+		}
+		forkName := resolvedFork()
+		ignorePatterns := viper.GetStringSlice("root.ignore")
+		tool := diffFlagTool
+		if tool == "" {
+			tool = viper.GetString("scadufax.diff_tool")
+		}
+
+		// Paths are given as in home, whatever is compared
+		var only []string
+		for _, arg := range args {
+			absPath, err := filepath.Abs(arg)
+			if err != nil {
+				return fmt.Errorf("failed to get abs path for %s: %w", arg, err)
+			}
+			rel, err := filepath.Rel(homeDir, absPath)
+			if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return fmt.Errorf("file %s is not in home directory %s", arg, homeDir)
+			}
+			only = append(only, filepath.ToSlash(rel))
+		}
+
+		if err := gitops.Checkout(localDir, forkName); err != nil {
+			return fmt.Errorf("failed to checkout fork branch %s: %w", forkName, err)
+		}
+		filter, err := fileFilter(localDir)
+		if err != nil {
+			return err
+		}
+
+		// Home against the fork, or, with --full, the fork against main
+		sourceDir, targetDir := localDir, homeDir
+		from, to := "home", "fork"
+		if diffFlagFull {
+			tempDir, err := reifyMain(localDir, forkName, ignorePatterns)
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(tempDir)
+			sourceDir, targetDir = tempDir, localDir
+			from, to = "fork", "main"
+		}
+
+		return diffDirs(sourceDir, targetDir, ignorePatterns, filter, only, func(target, src, dst string) error {
+			// Links stored in the repository are diffed by where they point
+			asLink := isSymlink(src)
+			a, b := from+"/"+target, to+"/"+target
+			if tool != "" {
+				return runDiffTool(tool, dst, src, a, b, asLink)
+			}
+			return printDiff(dst, src, a, b, asLink)
+		})
+	},
+}
+
+// diffDirs calls fn for every file of sourceDir that targetDir lacks or
+// holds differently, with its target name, its path in sourceDir and its
+// path in targetDir. Files are skipped and alternates resolved as check
+// does. Unless only is empty, only the targets it lists, or that are under
+// a directory it lists, are compared.
+func diffDirs(sourceDir, targetDir string, ignores []string, filter processor.Filter, only []string, fn func(target, src, dst string) error) error {
+	alts, err := findAlternates(sourceDir, "")
+	if err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(sourceDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(sourceDir, path)
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if d.Name() == ".git" || isMetaPath(rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		target, ok := alts.Target(filepath.ToSlash(rel))
+		if !ok || !selectedPath(target, only) {
+			return nil
+		}
+		if isIgnored(filepath.FromSlash(target), ignores) {
+			return nil
+		}
+		if ok, err := filter(target); err != nil || !ok {
+			return err
+		}
+
+		targetPath := filepath.Join(targetDir, filepath.FromSlash(target))
+		if _, err := os.Lstat(targetPath); err == nil && !areFilesDifferent(path, targetPath) {
+			return nil
+		}
+		return fn(target, path, targetPath)
+	})
+}
+
+// selectedPath reports whether target is one of only, or under one of
+// them. Everything is when only is empty.
+func selectedPath(target string, only []string) bool {
+	if len(only) == 0 {
+		return true
+	}
+	for _, p := range only {
+		if p == "." || target == p || strings.HasPrefix(target, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// diffContent returns what the file at path holds, as diffed: where it
+// points for a link when asLink, the plain text of an encrypted file, or
+// nothing for a missing file. binary is set for a binary file, whose
+// content is not read.
+func diffContent(path string, asLink bool) (content []byte, binary bool, err error) {
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if asLink && isSymlink(path) {
+		dest, err := os.Readlink(path)
+		if err != nil {
+			return nil, false, err
+		}
+		return []byte("-> " + dest + "\n"), false, nil
+	}
+	protected, err := isProtected(path)
+	if err != nil {
+		return nil, false, err
+	}
+	if !protected {
+		if binary, err := processor.IsBinary(path); err != nil || binary {
+			return nil, binary, err
+		}
+	}
+	content, _, err = readDecrypted(path)
+	return content, false, err
+}
+
+// printDiff prints a colored unified diff from the file at fromPath, named
+// from, to the one at toPath, named to, diffing links as diffContent does.
+// A missing file is diffed as empty.
+func printDiff(fromPath, toPath, from, to string, asLink bool) error {
+	bold := color.New(color.Bold).SprintFunc()
+	red := color.New(color.FgRed).SprintFunc()
+	green := color.New(color.FgGreen).SprintFunc()
+	cyan := color.New(color.FgCyan).SprintFunc()
+
+	a, binA, err := diffContent(fromPath, asLink)
+	if err != nil {
+		return err
+	}
+	b, binB, err := diffContent(toPath, asLink)
+	if err != nil {
+		return err
+	}
+	if binA || binB {
+		fmt.Printf("Binary files %s and %s differ\n", from, to)
+		return nil
+	}
+
+	if _, err := os.Lstat(fromPath); os.IsNotExist(err) {
+		from = os.DevNull
+	}
+	for _, line := range strings.SplitAfter(string(processor.UnifiedDiff(a, b, from, to, diffContext)), "\n") {
+		text := strings.TrimSuffix(line, "\n")
+		switch {
+		case text == "":
+			continue
+		case strings.HasPrefix(text, "--- "), strings.HasPrefix(text, "+++ "):
+			text = bold(text)
+		case strings.HasPrefix(text, "@@"):
+			text = cyan(text)
+		case strings.HasPrefix(text, "-"):
+			text = red(text)
+		case strings.HasPrefix(text, "+"):
+			text = green(text)
+		}
+		fmt.Println(text)
+	}
+	return nil
+}
+
+// runDiffTool hands the files at fromPath and toPath to the external diff
+// tool, a command given its arguments, with the two files appended. Files
+// that are not plain text on disk, encrypted, linked or missing, are handed
+// over as private copies of their content named from and to. Like diff, a
+// tool may exit with 1 to say the files differ.
+func runDiffTool(tool, fromPath, toPath, from, to string, asLink bool) error {
+	fields := strings.Fields(tool)
+	if len(fields) == 0 {
+		return fmt.Errorf("invalid diff tool %q", tool)
+	}
+
+	tempDir, err := os.MkdirTemp("", "scadu-diff-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	args := fields[1:]
+	for _, side := range []struct{ path, name string }{{fromPath, from}, {toPath, to}} {
+		plain, err := plainFile(side.path)
+		if err != nil {
+			return err
+		}
+		if plain && !asLink {
+			args = append(args, side.path)
+			continue
+		}
+		content, _, err := diffContent(side.path, asLink)
+		if err != nil {
+			return err
+		}
+		copyPath := filepath.Join(tempDir, filepath.FromSlash(side.name))
+		if err := os.MkdirAll(filepath.Dir(copyPath), 0700); err != nil {
+			return err
+		}
+		if err := os.WriteFile(copyPath, content, 0600); err != nil {
+			return err
+		}
+		args = append(args, copyPath)
+	}
+
+	cmd := exec.Command(fields[0], args...)
+	cmd.Env = os.Environ()
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return nil
+		}
+		return fmt.Errorf("diff tool %q failed: %w", tool, err)
+	}
+	return nil
+}
+
+// plainFile reports whether the file at path is a regular file, not
+// encrypted.
+func plainFile(path string) (bool, error) {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil || !info.Mode().IsRegular() {
+		return false, err
+	}
+	protected, err := isProtected(path)
+	return !protected, err
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+	diffCmd.Flags().BoolVar(&diffFlagFull, "full", false, "diff the fork against the main branch templates (reified)")
+	diffCmd.Flags().StringVar(&diffFlagTool, "tool", "", "external diff tool to hand the files to, e.g. delta or vimdiff")
+}
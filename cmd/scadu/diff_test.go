@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffCommand(t *testing.T) {
+	rootDir := setupTestDir(t)
+	homeDir := filepath.Join(rootDir, "home")
+	localDir := filepath.Join(rootDir, "local")
+	require.NoError(t, os.MkdirAll(homeDir, 0755))
+	require.NoError(t, os.MkdirAll(localDir, 0755))
+
+	viper.Reset()
+	viper.Set("scadufax.local_dir", localDir)
+	viper.Set("scadufax.home_dir", homeDir)
+	viper.Set("scadufax.fork", "testfork")
+	viper.Set("key", "testvalue")
+
+	repo, err := git.PlainInit(localDir, false)
+	require.NoError(t, err)
+	w, err := repo.Worktree()
+	require.NoError(t, err)
+	require.NoError(t, repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.ReferenceName("refs/heads/main"))))
+	commit := func(msg string) {
+		_, err := w.Add(".")
+		require.NoError(t, err)
+		_, err = w.Commit(msg, &git.CommitOptions{Author: &object.Signature{Name: "Test", Email: "test@local", When: time.Now()}})
+		require.NoError(t, err)
+	}
+
+	lines := "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\n"
+	require.NoError(t, os.WriteFile(filepath.Join(localDir, "lines.txt"), []byte(lines), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(localDir, "app.conf"), []byte("val = {{.key}}\n"), 0644))
+	commit("Initial main")
+
+	require.NoError(t, w.Checkout(&git.CheckoutOptions{Branch: plumbing.ReferenceName("refs/heads/testfork"), Create: true}))
+	require.NoError(t, os.WriteFile(filepath.Join(localDir, "app.conf"), []byte("val = testvalue\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(localDir, "new.txt"), []byte("new\n"), 0644))
+	commit("Fork reified state")
+
+	require.NoError(t, os.WriteFile(filepath.Join(homeDir, "lines.txt"), []byte("one\ntwo\nthree\nfour\nFIVE\nsix\nseven\neight\nnine\nten\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(homeDir, "app.conf"), []byte("val = testvalue\n"), 0644))
+
+	diff := func(args ...string) string {
+		return captureOutput(func() {
+			rootCmd.SetArgs(append([]string{"diff"}, args...))
+			assert.NoError(t, rootCmd.Execute())
+		})
+	}
+
+	// Home against the fork: what update would change
+	output := diff("--full=false")
+	assert.Contains(t, output, "--- home/lines.txt\n+++ fork/lines.txt\n@@ -2,7 +2,7 @@\n two\n three\n four\n-FIVE\n+five\n six\n seven\n eight\n")
+	assert.Contains(t, output, "--- "+os.DevNull+"\n+++ fork/new.txt\n@@ -0,0 +1 @@\n+new\n")
+	assert.NotContains(t, output, "app.conf")
+
+	// Only the paths asked for
+	output = diff("--full=false", filepath.Join(homeDir, "new.txt"))
+	assert.Contains(t, output, "fork/new.txt")
+	assert.NotContains(t, output, "lines.txt")
+
+	// An external tool gets both files
+	tool := filepath.Join(rootDir, "tool.sh")
+	require.NoError(t, os.WriteFile(tool, []byte("#!/bin/sh\necho \"tool $1 $2\"\nexit 1\n"), 0755))
+	output = diff("--full=false", "--tool", tool, filepath.Join(homeDir, "lines.txt"))
+	diffCmd.Flags().Set("tool", "")
+	assert.Contains(t, output, "tool "+filepath.Join(homeDir, "lines.txt")+" "+filepath.Join(localDir, "lines.txt"))
+
+	// The fork against main, rendered
+	require.NoError(t, w.Checkout(&git.CheckoutOptions{Branch: plumbing.ReferenceName("refs/heads/main")}))
+	require.NoError(t, os.WriteFile(filepath.Join(localDir, "app.conf"), []byte("val = {{.key}}\nmore = 1\n"), 0644))
+	commit("Update main template")
+
+	output = diff("--full")
+	assert.Contains(t, output, "--- fork/app.conf\n+++ main/app.conf\n@@ -1 +1,2 @@\n val = testvalue\n+more = 1\n")
+	assert.NotContains(t, output, "lines.txt")
+}
@@ -1,14 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/suderio/scadufax/pkg/crypt"
 	"github.com/suderio/scadufax/pkg/gitops"
 	"github.com/suderio/scadufax/pkg/processor"
 )
@@ -61,25 +64,70 @@ var editCmd = &cobra.Command{
 			}
 
 			// Target in Repo
-			repoPath := filepath.Join(localDir, rel)
+			repoRel, err := pickVariant(localDir, rel)
+			if err != nil {
+				return err
+			}
+			repoPath := filepath.Join(localDir, repoRel)
 			templateFiles = append(templateFiles, repoPath)
 			relPaths = append(relPaths, rel)
 		}
 
 		// 3. Perform Edit Workflow
-		return PerformEdit(templateFiles, localDir, homeDir)
+		homePaths := absPaths(args)
+		if err := runHook("pre_edit", homePaths, ""); err != nil {
+			return err
+		}
+		commitID, err := PerformEdit(templateFiles, localDir, homeDir)
+		if err != nil {
+			return err
+		}
+		postHook("post_edit", homePaths, commitID)
+		return nil
 	},
 }
 
 // PerformEdit handles the editing, verification, reification, installation, and committing of files.
-func PerformEdit(templateFiles []string, localDir, homeDir string) error {
+// It returns the SCADUFAX_ID of its last commit, if it made any.
+func PerformEdit(templateFiles []string, localDir, homeDir string) (string, error) {
 	// 1. Open Editor on Repo Paths
 	editor, err := resolveEditor()
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	cmd := exec.Command(editor, templateFiles...)
+	// Encrypted files are edited as decrypted copies in a private temp dir
+	plainDir, err := os.MkdirTemp("", "scadu-decrypted-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(plainDir)
+
+	editPaths := make([]string, len(templateFiles))
+	decrypted := map[string][]byte{}
+	for i, repoPath := range templateFiles {
+		editPaths[i] = repoPath
+		if _, err := os.Stat(repoPath); os.IsNotExist(err) {
+			continue
+		}
+		plain, encrypted, err := readDecrypted(repoPath)
+		if err != nil {
+			return "", err
+		}
+		if !encrypted {
+			continue
+		}
+		editPaths[i] = filepath.Join(plainDir, fmt.Sprint(i), filepath.Base(repoPath))
+		if err := os.MkdirAll(filepath.Dir(editPaths[i]), 0700); err != nil {
+			return "", err
+		}
+		if err := os.WriteFile(editPaths[i], plain, 0600); err != nil {
+			return "", err
+		}
+		decrypted[repoPath] = plain
+	}
+
+	cmd := exec.Command(editor, editPaths...)
 	cmd.Env = os.Environ()
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
@@ -87,7 +135,33 @@ func PerformEdit(templateFiles []string, localDir, homeDir string) error {
 
 	fmt.Printf("Editing %v in repo (branch main)...\n", templateFiles)
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("editor exited with error: %w", err)
+		return "", fmt.Errorf("editor exited with error: %w", err)
+	}
+
+	// Re-encrypt what changed; untouched files keep their ciphertext
+	for i, repoPath := range templateFiles {
+		plain, ok := decrypted[repoPath]
+		if !ok {
+			continue
+		}
+		edited, err := os.ReadFile(editPaths[i])
+		if err != nil {
+			return "", err
+		}
+		if bytes.Equal(plain, edited) {
+			continue
+		}
+		key, err := loadKey()
+		if err != nil {
+			return "", err
+		}
+		sealed, err := crypt.Encrypt(key, edited)
+		if err != nil {
+			return "", fmt.Errorf("failed to encrypt %s: %w", repoPath, err)
+		}
+		if err := os.WriteFile(repoPath, sealed, 0600); err != nil {
+			return "", err
+		}
 	}
 
 	// 2. Post-Edit Logic
@@ -99,12 +173,12 @@ func PerformEdit(templateFiles []string, localDir, homeDir string) error {
 	for _, repoPath := range templateFiles {
 		fileRel, err := filepath.Rel(localDir, repoPath)
 		if err != nil {
-			return fmt.Errorf("path error: %w", err)
+			return "", fmt.Errorf("path error: %w", err)
 		}
 
 		isDirty, err := gitops.IsDirty(localDir, fileRel)
 		if err != nil {
-			return fmt.Errorf("failed to check status for %s: %w", repoPath, err)
+			return "", fmt.Errorf("failed to check status for %s: %w", repoPath, err)
 		}
 
 		if isDirty {
@@ -115,7 +189,7 @@ func PerformEdit(templateFiles []string, localDir, homeDir string) error {
 
 	if len(dirtyFiles) == 0 {
 		fmt.Println("No changes detected.")
-		return nil
+		return "", nil
 	}
 
 	fmt.Printf("Detected changes in: %v\n", dirtyFiles)
@@ -123,32 +197,96 @@ func PerformEdit(templateFiles []string, localDir, homeDir string) error {
 	// 3. Reify and Install
 	tempDir, err := os.MkdirTemp("", "scadu-reify-*")
 	if err != nil {
-		return fmt.Errorf("failed to create temp dir: %w", err)
+		return "", fmt.Errorf("failed to create temp dir: %w", err)
 	}
 	defer os.RemoveAll(tempDir)
 
 	envPath := filepath.Join(homeDir, ".env")
-	secretFn, err := processor.GetSecretFn(envPath, false)
+	registry, err := newSecrets(envPath, false)
 	if err != nil {
-		return err
+		return "", err
 	}
+	secretFn := registry.Lookup
+
+	data := templateData()
 
-	data := viper.AllSettings()
+	includes, err := loadIncludes(localDir, resolvedFork())
+	if err != nil {
+		return "", err
+	}
+	key, err := loadKey()
+	if err != nil {
+		return "", err
+	}
+	st, err := loadState()
+	if err != nil {
+		return "", err
+	}
 
+	var commitID string
+	var installed []string
 	for _, rel := range dirtyFiles {
 		repoPath := filepath.Join(localDir, rel)
 		tempPath := filepath.Join(tempDir, rel)
-		finalPath := filepath.Join(homeDir, rel)
+
+		// Shared templates are only committed, never installed
+		if isMetaPath(rel) {
+			fmt.Printf("Committing %s...\n", rel)
+			if commitID, err = commitFile(localDir, rel, fmt.Sprintf("Update %s via scadu edit", rel)); err != nil {
+				return "", fmt.Errorf("failed to commit %s: %w", rel, err)
+			}
+			continue
+		}
+
+		// Variants for other machines are only committed, too
+		target, active := siblingAlternates(localDir, rel).Target(filepath.ToSlash(processor.TargetName(rel, templateSuffix())))
+		if !active {
+			fmt.Printf("%s is not active on this machine. Committing it...\n", rel)
+			if commitID, err = commitFile(localDir, rel, fmt.Sprintf("Update %s via scadu edit", rel)); err != nil {
+				return "", fmt.Errorf("failed to commit %s: %w", rel, err)
+			}
+			continue
+		}
+		finalPath := filepath.Join(homeDir, filepath.FromSlash(target))
+
+		delims, err := delimsFor(localDir, target)
+		if err != nil {
+			return "", err
+		}
 
 		fmt.Printf("Reifying %s...\n", rel)
-		if err := processor.ReifyFile(repoPath, tempPath, data, secretFn); err != nil {
-			return fmt.Errorf("reification failed for %s: %w", rel, err)
+		renderer, err := processor.NewRenderer(processor.RendererOptions{
+			Data:    []map[string]any{data},
+			Secrets: secretFn,
+			Options: []processor.Option{includes, delims, processor.WithTemplateSuffix(templateSuffix()), processor.WithKey(key)},
+		})
+		if err != nil {
+			return "", err
+		}
+		if err := renderer.RenderFile(repoPath, tempPath); err != nil {
+			return "", fmt.Errorf("reification failed for %s: %w", rel, err)
+		}
+
+		// The manifest has the last word, on the file and its directories
+		rules, err := loadManifest(localDir)
+		if err != nil {
+			return "", err
 		}
 
-		fmt.Printf("Installing to %s...\n", finalPath)
+		// A linked file is written to the reified directory, home linking to it
+		installPath := finalPath
+		linked := rules.Symlink(target)
+		if linked {
+			installPath = reifiedPath(target)
+			if err := os.MkdirAll(filepath.Dir(installPath), 0755); err != nil {
+				return "", err
+			}
+		}
+
+		fmt.Printf("Installing to %s...\n", installPath)
 		content, err := os.ReadFile(tempPath)
 		if err != nil {
-			return err
+			return "", err
 		}
 
 		// Attempt to preserve mode from repo file
@@ -157,20 +295,101 @@ func PerformEdit(templateFiles []string, localDir, homeDir string) error {
 		if err == nil {
 			mode = info.Mode()
 		}
+		// Encrypted files stay private once decrypted
+		_, private := decrypted[repoPath]
+		if private {
+			mode = 0600
+		}
+		perm, fixed := rules.Perm(target)
+		if fixed {
+			mode = perm
+		}
+		if err := installParentDirs(homeDir, rules, target); err != nil {
+			return "", err
+		}
 
-		if err := os.WriteFile(finalPath, content, mode); err != nil {
-			return fmt.Errorf("failed to install file: %w", err)
+		if err := os.WriteFile(installPath, content, mode); err != nil {
+			return "", fmt.Errorf("failed to install file: %w", err)
+		}
+		if private || fixed {
+			if err := os.Chmod(installPath, mode); err != nil {
+				return "", fmt.Errorf("failed to install file: %w", err)
+			}
+		}
+		if linked && linkDrift(finalPath, installPath) {
+			if err := linkFile(installPath, finalPath); err != nil {
+				return "", err
+			}
 		}
+		installed = append(installed, finalPath)
 
 		fmt.Printf("Committing %s...\n", rel)
-		msg := GenerateCommitMessage(fmt.Sprintf("Update %s via scadu edit", rel))
-		if err := gitops.CommitFile(localDir, rel, msg); err != nil {
-			return fmt.Errorf("failed to commit %s: %w", rel, err)
+		if commitID, err = commitFile(localDir, rel, fmt.Sprintf("Update %s via scadu edit", rel)); err != nil {
+			return "", fmt.Errorf("failed to commit %s: %w", rel, err)
+		}
+
+		// The fork gets what was reified, once it catches up with the commit
+		if err := recordInstall(st, target, tempPath, finalPath, commitID); err != nil {
+			return "", err
 		}
+		if err := st.Save(); err != nil {
+			return "", err
+		}
+	}
+
+	if len(installed) > 0 {
+		postHook("post_install", installed, commitID)
+	}
+
+	// Scripts run once, or whenever their content changes
+	scripts, err := pendingScripts(localDir, homeDir, st)
+	if err != nil {
+		return "", err
+	}
+	if err := runScripts(scripts, homeDir, st); err != nil {
+		return "", err
 	}
 
 	fmt.Println("Done.")
-	return nil
+	return commitID, nil
+}
+
+// pickVariant maps rel, relative to home, to the repository file to edit.
+// When the file has several alternates it asks which one, defaulting to the
+// variant active on this machine.
+func pickVariant(localDir, rel string) (string, error) {
+	def := repoRelFor(localDir, rel)
+	variants := siblingAlternates(localDir, rel).Variants(filepath.ToSlash(rel))
+	if len(variants) < 2 {
+		return def, nil
+	}
+
+	fmt.Printf("%s has several variants:\n", rel)
+	choice := 0
+	for i, variant := range variants {
+		variant = filepath.FromSlash(variant)
+		marker := ""
+		if variant == def {
+			marker = " (active)"
+			choice = i + 1
+		}
+		fmt.Printf("  [%d] %s%s\n", i+1, variant, marker)
+	}
+	fmt.Printf("Which one do you want to edit? [%d]: ", choice)
+
+	resp, _ := readLine(os.Stdin)
+	resp = strings.TrimSpace(resp)
+	if resp == "" {
+		if choice == 0 {
+			return "", fmt.Errorf("no variant of %s is active on this machine; pick one", rel)
+		}
+		return def, nil
+	}
+	n, err := strconv.Atoi(resp)
+	if err != nil || n < 1 || n > len(variants) {
+		return "", fmt.Errorf("invalid choice %q", resp)
+	}
+	return filepath.FromSlash(variants[n-1]), nil
 }
 
 func resolveEditor() (string, error) {
@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 	"time"
 
@@ -30,6 +31,7 @@ func TestEditCommand_Integration(t *testing.T) {
 	viper.Set("scadufax.local_dir", localDir)
 	viper.Set("scadufax.home_dir", homeDir)
 	viper.Set("scadufax.fork", "testfork")
+	viper.Set("scadufax.state_dir", filepath.Join(rootDir, "state"))
 
 	// Setup Repo
 	repo, err := git.PlainInit(localDir, false)
@@ -121,3 +123,74 @@ echo "# Edited by mock" >> "$1"
 	assert.Contains(t, sContent, "secret = supersecret")
 	assert.Contains(t, sContent, "# Edited by mock")
 }
+
+func TestEditCommand_Hooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping shell script hook test on Windows")
+	}
+
+	rootDir := setupTestDir(t)
+	homeDir := filepath.Join(rootDir, "home")
+	localDir := filepath.Join(rootDir, "local")
+	require.NoError(t, os.MkdirAll(homeDir, 0755))
+	require.NoError(t, os.MkdirAll(localDir, 0755))
+
+	viper.Reset()
+	viper.Set("scadufax.local_dir", localDir)
+	viper.Set("scadufax.home_dir", homeDir)
+	viper.Set("scadufax.fork", "testfork")
+	viper.Set("scadufax.state_dir", filepath.Join(rootDir, "state"))
+
+	repo, err := git.PlainInit(localDir, false)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(localDir, ".tmux.conf"), []byte("set -g mouse on\n"), 0644))
+	w, err := repo.Worktree()
+	require.NoError(t, err)
+	_, err = w.Add(".")
+	require.NoError(t, err)
+	_, err = w.Commit("Initial commit", &git.CommitOptions{
+		Author: &object.Signature{Name: "Test", Email: "test@local", When: time.Now()},
+	})
+	require.NoError(t, err)
+	head := func() string {
+		ref, err := repo.Head()
+		require.NoError(t, err)
+		return ref.Hash().String()
+	}
+
+	editor := filepath.Join(rootDir, "editor.sh")
+	os.Setenv("EDITOR", editor)
+	defer os.Unsetenv("EDITOR")
+	homeFile := filepath.Join(homeDir, ".tmux.conf")
+	log := filepath.Join(rootDir, "hooks.log")
+
+	// A failing pre_commit hook stops the commit
+	require.NoError(t, os.WriteFile(editor, []byte("#!/bin/sh\necho '# TODO' >> \"$1\"\n"), 0755))
+	viper.Set("hooks.pre_commit", `! grep -q TODO "$SCADUFAX_PATHS"`)
+	before := head()
+	rootCmd.SetArgs([]string{"edit", homeFile})
+	err = rootCmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "pre_commit hook")
+	assert.Equal(t, before, head())
+
+	// Post hooks learn what changed and the SCADUFAX_ID of the commit
+	require.NoError(t, os.WriteFile(editor, []byte("#!/bin/sh\nsed -i 's/# TODO/# done/' \"$1\"\n"), 0755))
+	viper.Set("hooks.post_install", []any{
+		`echo "$SCADUFAX_HOOK $SCADUFAX_PATHS" >> ` + log,
+		`echo "$SCADUFAX_ID" >> ` + log,
+	})
+	viper.Set("hooks.post_edit", `echo "$SCADUFAX_HOOK $SCADUFAX_PATHS $SCADUFAX_ID" >> `+log)
+	rootCmd.SetArgs([]string{"edit", homeFile})
+	require.NoError(t, rootCmd.Execute())
+
+	ref, err := repo.Head()
+	require.NoError(t, err)
+	commit, err := repo.CommitObject(ref.Hash())
+	require.NoError(t, err)
+	content, err := os.ReadFile(log)
+	require.NoError(t, err)
+	id := strings.TrimSpace(commit.Message[strings.Index(commit.Message, "SCADUFAX_ID: ")+len("SCADUFAX_ID: "):])
+	assert.Equal(t, "post_install "+homeFile+"\n"+id+"\npost_edit "+homeFile+" "+id+"\n", string(content))
+
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"github.com/suderio/scadufax/pkg/gitops"
+)
+
+// hookCommands returns the shell commands configured for the hook name
+// under [hooks]: a string, or a list of them.
+func hookCommands(name string) ([]string, error) {
+	switch value := viper.Get("hooks." + name).(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []string{value}, nil
+	case []string:
+		return value, nil
+	case []any:
+		commands := make([]string, len(value))
+		for i, v := range value {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid hook %s: %v is not a command", name, v)
+			}
+			commands[i] = s
+		}
+		return commands, nil
+	default:
+		return nil, fmt.Errorf("invalid hook %s: must be a command or a list of commands", name)
+	}
+}
+
+// runHook runs the commands of the hook name in turn, from home, stopping
+// at the first that fails. They find the hook in SCADUFAX_HOOK, the
+// absolute paths it is about in SCADUFAX_PATHS, one per line, and, when
+// known, the SCADUFAX_ID of the change in SCADUFAX_ID.
+func runHook(name string, paths []string, id string) error {
+	commands, err := hookCommands(name)
+	if err != nil {
+		return err
+	}
+
+	homeDir := viper.GetString("scadufax.home_dir")
+	if homeDir == "" {
+		homeDir, _ = os.UserHomeDir()
+	}
+	for _, command := range commands {
+		cmd := exec.Command("sh", "-c", command)
+		if runtime.GOOS == "windows" {
+			cmd = exec.Command("cmd", "/C", command)
+		}
+		cmd.Dir = homeDir
+		cmd.Env = append(os.Environ(),
+			"SCADUFAX_HOOK="+name,
+			"SCADUFAX_PATHS="+strings.Join(paths, "\n"),
+			"SCADUFAX_ID="+id,
+		)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = messages()
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s hook %q failed: %w", name, command, err)
+		}
+	}
+	return nil
+}
+
+// postHook runs the hook name once the work it follows is done, so a
+// failure is only reported.
+func postHook(name string, paths []string, id string) {
+	if err := runHook(name, paths, id); err != nil {
+		fmt.Fprintf(messages(), "Warning: %v\n", err)
+	}
+}
+
+// absPaths returns the absolute form of paths, as given on the command line.
+func absPaths(paths []string) []string {
+	abs := make([]string, len(paths))
+	for i, p := range paths {
+		if a, err := filepath.Abs(p); err == nil {
+			p = a
+		}
+		abs[i] = p
+	}
+	return abs
+}
+
+// commitFile commits rel in the repository at localDir with msg and a new
+// SCADUFAX_ID, once the pre_commit hook lets it, and returns that ID.
+func commitFile(localDir, rel, msg string) (string, error) {
+	id := uuid.New().String()
+	if err := runHook("pre_commit", []string{filepath.Join(localDir, rel)}, id); err != nil {
+		return "", err
+	}
+	if err := gitops.CommitFile(localDir, rel, commitMessage(msg, id)); err != nil {
+		return "", err
+	}
+	return id, nil
+}
@@ -8,7 +8,9 @@ import (
 
 	"github.com/pelletier/go-toml/v2"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 	"github.com/suderio/scadufax/pkg/gitops"
+	"github.com/suderio/scadufax/pkg/processor"
 )
 
 // Defaults
@@ -21,6 +23,7 @@ var (
 	flagLocalDir  string
 	flagHomeDir   string
 	flagFork      string
+	flagPrompt    bool
 )
 
 // Config structures for toml encoding
@@ -154,7 +157,32 @@ var initCmd = &cobra.Command{
 			}
 		}
 
+		// Ask up front for what the templates need, so reify runs unattended
+		if flagPrompt {
+			viper.Set("scadufax.local_dir", targetLocalDir)
+			viper.Set("scadufax.fork", cfg.Fork)
+			filter, err := fileFilter(targetLocalDir)
+			if err != nil {
+				return err
+			}
+			delims, err := manifestDelims(targetLocalDir)
+			if err != nil {
+				return err
+			}
+			opts := []processor.Option{
+				processor.WithTemplateSuffix(templateSuffix()),
+				processor.WithMachine(machine()),
+				processor.WithFilter(filter),
+				delims,
+			}
+			if err := promptMissing(targetLocalDir, targetConfigDir, templateData(), opts); err != nil {
+				return err
+			}
+		}
+
 		fmt.Println("Initialization complete.")
+		mainID, _ := gitops.GetHeadID(targetLocalDir)
+		postHook("post_init", []string{targetLocalDir}, mainID)
 		return nil
 	},
 }
@@ -165,4 +193,5 @@ func init() {
 	initCmd.Flags().StringVar(&flagLocalDir, "local-dir", "", "local directory for repo")
 	initCmd.Flags().StringVar(&flagHomeDir, "home-dir", "", "home directory")
 	initCmd.Flags().StringVar(&flagFork, "fork", "", "fork name (defaults to hostname)")
+	initCmd.Flags().BoolVar(&flagPrompt, "prompt", false, "ask for the values templates need and save them to local.toml")
 }
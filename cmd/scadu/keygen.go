@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/suderio/scadufax/pkg/crypt"
+	"github.com/suderio/scadufax/pkg/gitops"
+)
+
+var keygenForce bool
+
+var keygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "Generate this machine's key pair for an encrypted fork branch",
+	Long: `Generates an X25519 key pair for this machine.
+
+The private key is written to ~/.config/scadufax/fork.key (or scadufax.fork_key)
+and never leaves the machine. The public key is committed to the main branch as
+.scadufax/recipients/<fork>.pub, so the pipeline can run 'scadu reify --seal' to
+write secret-bearing files to the fork encrypted. 'update' and 'check' decrypt
+them with the private key.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		localDir := viper.GetString("scadufax.local_dir")
+		if localDir == "" {
+			home, _ := os.UserHomeDir()
+			localDir = filepath.Join(home, ".local", "share", "scadufax")
+		}
+		forkName := resolvedFork()
+
+		keyFile := forkKeyPath()
+		if _, err := os.Stat(keyFile); err == nil && !keygenForce {
+			return fmt.Errorf("private key %s already exists; use --force to replace it", keyFile)
+		}
+
+		if err := gitops.Checkout(localDir, "main"); err != nil {
+			return fmt.Errorf("failed to checkout main: %w", err)
+		}
+
+		kp, err := crypt.GenerateKeyPair()
+		if err != nil {
+			return fmt.Errorf("failed to generate key pair: %w", err)
+		}
+		if err := kp.Save(keyFile); err != nil {
+			return err
+		}
+		fmt.Printf("Private key written to %s.\n", keyFile)
+
+		pubPath := recipientPath(localDir, forkName)
+		if err := os.MkdirAll(filepath.Dir(pubPath), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(pubPath, []byte(crypt.EncodePublicKey(kp.Public)), 0644); err != nil {
+			return fmt.Errorf("failed to write public key: %w", err)
+		}
+
+		rel, err := filepath.Rel(localDir, pubPath)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Committing %s...\n", rel)
+		if _, err := commitFile(localDir, rel, fmt.Sprintf("Add public key of %s via scadu keygen", forkName)); err != nil {
+			return fmt.Errorf("failed to commit %s: %w", rel, err)
+		}
+
+		fmt.Println("Done.")
+		return nil
+	},
+}
+
+func init() {
+	keygenCmd.Flags().BoolVar(&keygenForce, "force", false, "replace an existing private key")
+	rootCmd.AddCommand(keygenCmd)
+}
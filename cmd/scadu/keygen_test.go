@@ -0,0 +1,119 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suderio/scadufax/pkg/crypt"
+	"github.com/suderio/scadufax/pkg/gitops"
+)
+
+func TestKeygenCommand_SealedFork(t *testing.T) {
+	rootDir := setupTestDir(t)
+	localDir := filepath.Join(rootDir, "local")
+	pipelineDir := filepath.Join(rootDir, "pipeline")
+	keyFile := filepath.Join(rootDir, "fork.key")
+
+	err := os.MkdirAll(localDir, 0755)
+	require.NoError(t, err)
+	gitops.InitRepo(localDir, "git@github.com:test/repo.git")
+	repo, _ := git.PlainOpen(localDir)
+	w, _ := repo.Worktree()
+	err = os.WriteFile(filepath.Join(localDir, "README.md"), []byte("repo"), 0644)
+	require.NoError(t, err)
+	_, err = w.Add("README.md")
+	require.NoError(t, err)
+	_, err = w.Commit("Init", &git.CommitOptions{
+		Author: &object.Signature{Name: "Test", Email: "test@local", When: time.Now()},
+	})
+	require.NoError(t, err)
+	headRef, err := repo.Head()
+	require.NoError(t, err)
+	err = repo.Storer.SetReference(plumbing.NewHashReference("refs/heads/main", headRef.Hash()))
+	require.NoError(t, err)
+
+	viper.Reset()
+	viper.Set("scadufax.local_dir", localDir)
+	viper.Set("scadufax.fork", "box")
+	viper.Set("scadufax.fork_key", keyFile)
+
+	cmd := rootCmd
+	cmd.SetArgs([]string{"keygen"})
+	err = cmd.Execute()
+	require.NoError(t, err)
+
+	// Private key stays local and private
+	info, err := os.Stat(keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// Public key committed to main
+	pubPath := filepath.Join(localDir, ".scadufax", "recipients", "box.pub")
+	_, err = crypt.LoadPublicKey(pubPath)
+	require.NoError(t, err)
+	headRef, err = repo.Head()
+	require.NoError(t, err)
+	commit, err := repo.CommitObject(headRef.Hash())
+	require.NoError(t, err)
+	assert.Contains(t, commit.Message, "Add public key of box via scadu keygen")
+
+	// A second run does not clobber the key
+	cmd.SetArgs([]string{"keygen"})
+	err = cmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "already exists")
+
+	// The pipeline reifies a checkout of main with --seal
+	err = os.MkdirAll(filepath.Join(pipelineDir, ".scadufax", "recipients"), 0755)
+	require.NoError(t, err)
+	pub, err := os.ReadFile(pubPath)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(pipelineDir, ".scadufax", "recipients", "box.pub"), pub, 0644)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(pipelineDir, ".env"), []byte("TOKEN=hunter2"), 0600)
+	require.NoError(t, err)
+	secretPath := filepath.Join(pipelineDir, ".netrc")
+	err = os.WriteFile(secretPath, []byte(`password {{ "TOKEN" | secret }}`), 0644)
+	require.NoError(t, err)
+	plainPath := filepath.Join(pipelineDir, ".profile")
+	err = os.WriteFile(plainPath, []byte(`# {{ .scadufax.fork }}`), 0644)
+	require.NoError(t, err)
+
+	defer reifyCmd.Flags().Set("seal", "false")
+	cmd.SetArgs([]string{"reify", pipelineDir, "--secret=true", "--seal"})
+	err = cmd.Execute()
+	require.NoError(t, err)
+
+	raw, err := os.ReadFile(secretPath)
+	require.NoError(t, err)
+	assert.True(t, crypt.IsSealed(raw))
+	assert.NotContains(t, string(raw), "hunter2")
+
+	content, err := os.ReadFile(plainPath)
+	require.NoError(t, err)
+	assert.Equal(t, "# box", string(content))
+
+	// This machine reads the sealed file back with its private key
+	home := filepath.Join(rootDir, "home", ".netrc")
+	err = installFile(secretPath, home)
+	require.NoError(t, err)
+	content, err = os.ReadFile(home)
+	require.NoError(t, err)
+	assert.Equal(t, "password hunter2", string(content))
+	info, err = os.Stat(home)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	assert.False(t, areFilesDifferent(secretPath, home))
+	err = os.WriteFile(home, []byte("password changed"), 0600)
+	require.NoError(t, err)
+	assert.True(t, areFilesDifferent(secretPath, home))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/viper"
+	"github.com/suderio/scadufax/pkg/manifest"
+)
+
+// reifiedDir returns where files the manifest installs as links live, home
+// linking to them.
+func reifiedDir() string {
+	if dir := viper.GetString("scadufax.reified_dir"); dir != "" {
+		return dir
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".local", "share", "scadufax-reified")
+}
+
+// reifiedPath returns where the file installed as target lives when the
+// manifest installs it as a link.
+func reifiedPath(target string) string {
+	return filepath.Join(reifiedDir(), filepath.FromSlash(target))
+}
+
+// isSymlink reports whether path is a symbolic link.
+func isSymlink(path string) bool {
+	info, err := os.Lstat(path)
+	return err == nil && info.Mode()&fs.ModeSymlink != 0
+}
+
+// linkDrift reports whether path is not a link to dest, or one whose
+// target is gone.
+func linkDrift(path, dest string) bool {
+	current, err := os.Readlink(path)
+	if err != nil || current != dest {
+		return true
+	}
+	_, err = os.Stat(path)
+	return err != nil
+}
+
+// linkFile replaces path with a symbolic link to dest.
+func linkFile(dest, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	if err := os.Symlink(dest, path); err != nil {
+		return fmt.Errorf("failed to link %s: %w", path, err)
+	}
+	return nil
+}
+
+// copyLink recreates the symbolic link src at dst, pointing to the same
+// place.
+func copyLink(src, dst string) error {
+	dest, err := os.Readlink(src)
+	if err != nil {
+		return err
+	}
+	return linkFile(dest, dst)
+}
+
+// installLink installs the repository file src as target the way the
+// manifest m says to link it: in the reified directory, with its mode, and
+// home, at dst, linking to it.
+func installLink(m *manifest.Manifest, src, target, dst string) error {
+	stored := reifiedPath(target)
+	if err := installFile(src, stored); err != nil {
+		return err
+	}
+	if err := applyPerm(m, target, stored); err != nil {
+		return err
+	}
+	if linkDrift(dst, stored) {
+		return linkFile(stored, dst)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/suderio/scadufax/pkg/gitops"
+	"github.com/suderio/scadufax/pkg/manifest"
+	"github.com/suderio/scadufax/pkg/processor"
+)
+
+// machinesDir holds the configuration of every machine, relative to the
+// repository root, for `lint --all`.
+var machinesDir = filepath.Join(processor.MetaDir, "machines")
+
+var lintAll bool
+
+var lintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Check every template in the main branch for errors",
+	Long: `Parses and dry-renders every template in the main branch with the local
+configuration, without looking up secrets, and reports every problem at once:
+syntax errors, keys missing from the data (with suggestions for misspelled
+ones) and errors raised while rendering.
+
+With --all, templates are also checked against the configuration of every
+machine in .scadufax/machines/<fork>.toml, honouring the manifest and
+alternates for each. Exits with an error if any problem is found.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		localDir := viper.GetString("scadufax.local_dir")
+		if localDir == "" {
+			home, _ := os.UserHomeDir()
+			localDir = filepath.Join(home, ".local", "share", "scadufax")
+		}
+
+		if err := gitops.Checkout(localDir, "main"); err != nil {
+			return fmt.Errorf("failed to checkout main: %w", err)
+		}
+
+		machines := []lintMachine{{Name: resolvedFork(), Data: templateData()}}
+		if lintAll {
+			others, err := loadMachines(localDir)
+			if err != nil {
+				return err
+			}
+			machines = append(machines, others...)
+		}
+
+		total := 0
+		for _, m := range machines {
+			problems, err := lintMachineData(localDir, m)
+			if err != nil {
+				return err
+			}
+			for _, p := range problems {
+				printProblem(p, m.Name, lintAll)
+			}
+			total += len(problems)
+		}
+
+		if total > 0 {
+			return fmt.Errorf("%d problem(s) found", total)
+		}
+		fmt.Println("No problems found.")
+		return nil
+	},
+}
+
+// lintMachine is a machine templates are checked against.
+type lintMachine struct {
+	Name string
+	Data map[string]any
+}
+
+// loadMachines reads the configuration of every machine committed under
+// machinesDir. A machine is described under [sys]; its fork defaults to the
+// file name.
+func loadMachines(localDir string) ([]lintMachine, error) {
+	paths, err := filepath.Glob(filepath.Join(localDir, machinesDir, "*.toml"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	var machines []lintMachine
+	for _, path := range paths {
+		v := viper.New()
+		v.SetConfigFile(path)
+		v.SetConfigType("toml")
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("failed to read machine %s: %w", path, err)
+		}
+
+		name := strings.TrimSuffix(filepath.Base(path), ".toml")
+		fork := v.GetString("scadufax.fork")
+		if fork == "" {
+			fork = name
+		}
+		data := withSys(v.AllSettings(), map[string]any{
+			"os":       "",
+			"arch":     "",
+			"hostname": "",
+			"fork":     fork,
+		})
+		machines = append(machines, lintMachine{Name: name, Data: data})
+	}
+	return machines, nil
+}
+
+// lintMachineData checks every template in main that applies to m.
+func lintMachineData(localDir string, m lintMachine) ([]processor.Problem, error) {
+	suffix := templateSuffix()
+	sys := machineOf(m.Data)
+
+	set, err := processor.LoadIncludes(filepath.Join(localDir, processor.IncludesDir), sys.Fork)
+	if err != nil {
+		// The shared templates themselves are broken
+		return []processor.Problem{{File: processor.IncludesDir, Line: 1, Msg: err.Error()}}, nil
+	}
+	filter, err := manifestFilter(localDir, m.Data)
+	if err != nil {
+		return nil, err
+	}
+	alts, err := findAlternatesFor(localDir, suffix, sys)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := manifest.Load(filepath.Join(localDir, processor.MetaDir, manifest.FileName))
+	if err != nil {
+		return nil, err
+	}
+	opts := []processor.Option{processor.WithIncludes(set), processor.WithTemplateSuffix(suffix)}
+
+	var problems []processor.Problem
+	err = filepath.WalkDir(localDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(localDir, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" || isMetaPath(rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// Links are kept as they are, never rendered
+		if !processor.IsTemplate(rel, suffix) || d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+
+		target, ok := alts.Target(filepath.ToSlash(processor.TargetName(rel, suffix)))
+		if !ok {
+			return nil
+		}
+		if ok, err := filter(target); err != nil || !ok {
+			return err
+		}
+
+		// Encrypted files look binary on disk: lint their plain text
+		protected, err := isProtected(path)
+		if err != nil {
+			return err
+		}
+		if !protected {
+			if binary, err := processor.IsBinary(path); err != nil || binary {
+				return err
+			}
+		}
+		content, _, err := readDecrypted(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping %s: %v\n", rel, err)
+			return nil
+		}
+
+		delims := processor.WithDelims(rules.Delims(target))
+		problems = append(problems, processor.Lint(filepath.ToSlash(rel), content, m.Data, append(opts, delims)...)...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to lint main: %w", err)
+	}
+	return problems, nil
+}
+
+func printProblem(p processor.Problem, machine string, showMachine bool) {
+	red := color.New(color.FgRed).SprintFunc()
+
+	prefix := ""
+	if showMachine {
+		prefix = "[" + machine + "] "
+	}
+	fmt.Printf("%s%s\n", prefix, red(p.String()))
+	if p.Snippet == "" {
+		return
+	}
+	fmt.Printf("    %s\n", p.Snippet)
+	if p.Col > 0 && p.Col <= len(p.Snippet)+1 {
+		// Keep tabs so the caret lines up
+		pad := strings.Map(func(r rune) rune {
+			if r == '\t' {
+				return r
+			}
+			return ' '
+		}, p.Snippet[:p.Col-1])
+		fmt.Printf("    %s^\n", pad)
+	}
+}
+
+func init() {
+	lintCmd.Flags().BoolVar(&lintAll, "all", false, "also check against every machine in .scadufax/machines/")
+	rootCmd.AddCommand(lintCmd)
+}
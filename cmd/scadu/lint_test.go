@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/suderio/scadufax/pkg/crypt"
+)
+
+func TestLintCommand_Integration(t *testing.T) {
+	rootDir := setupTestDir(t)
+	localDir := filepath.Join(rootDir, "local")
+
+	repo, err := git.PlainInit(localDir, false)
+	require.NoError(t, err)
+	w, _ := repo.Worktree()
+
+	files := map[string]string{
+		".gitconfig":         "[user]\n\temail = {{ .root.emial }}\n\tname = {{ .root.name }}\n",
+		".bashrc":            "{{ range .root.paths }}{{ .dir }}{{ end }}{{ $.root.nmae }}",
+		".profile":           "{{ if .root.name }}{{ .root.name | upper }}{{ end }}",
+		".vimrc":             "{{ .root.name | nosuchfunc }}",
+		".work##fork.server": "{{ .root.server_only }}",
+		".tmux.conf":         "# scadufax: delims=\"[[ ]]\"\nset -g status-left '{{ literal }}'\n[[ .root.nmae ]]\n",
+	}
+	for name, content := range files {
+		err := os.WriteFile(filepath.Join(localDir, name), []byte(content), 0644)
+		require.NoError(t, err)
+		_, err = w.Add(name)
+		require.NoError(t, err)
+	}
+	err = os.MkdirAll(filepath.Join(localDir, ".scadufax", "machines"), 0755)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(localDir, ".scadufax", "machines", "server.toml"), []byte("[root]\nname = \"srv\"\nemail = \"srv@example.com\"\n"), 0644)
+	require.NoError(t, err)
+	_, err = w.Add(".scadufax/machines/server.toml")
+	require.NoError(t, err)
+	_, err = w.Commit("Init", &git.CommitOptions{Author: &object.Signature{Name: "T", Email: "t", When: time.Now()}})
+	require.NoError(t, err)
+	head, _ := repo.Head()
+	repo.Storer.SetReference(plumbing.NewHashReference(plumbing.ReferenceName("refs/heads/main"), head.Hash()))
+
+	viper.Reset()
+	viper.Set("scadufax.local_dir", localDir)
+	viper.Set("scadufax.fork", "laptop")
+	viper.Set("root.name", "Me")
+	viper.Set("root.email", "me@example.com")
+	viper.Set("root.paths", []any{map[string]any{"dir": "/bin"}})
+
+	t.Run("Reports Every Problem", func(t *testing.T) {
+		lintAll = false
+		cmd := rootCmd
+		cmd.SetArgs([]string{"lint"})
+
+		var err error
+		output := captureOutput(func() {
+			err = cmd.Execute()
+		})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "4 problem(s) found")
+
+		assert.Contains(t, output, `.gitconfig:2:19: no key "emial" in .root (did you mean "email"?)`)
+		assert.Contains(t, output, "\temail = {{ .root.emial }}\n    \t                 ^")
+		assert.Contains(t, output, `.bashrc:1:53: no key "nmae" in .root (did you mean "name"?)`)
+		assert.Contains(t, output, `.vimrc:1: function "nosuchfunc" not defined`)
+		// Lines count the delimiters header
+		assert.Contains(t, output, `.tmux.conf:3:10: no key "nmae" in .root (did you mean "name"?)`)
+		assert.NotContains(t, output, ".profile")
+		// Not the variant for this machine
+		assert.NotContains(t, output, ".work")
+	})
+
+	t.Run("Every Machine", func(t *testing.T) {
+		viper.Set("root.emial", "typo@example.com")
+		viper.Set("root.nmae", "typo")
+		cmd := rootCmd
+		cmd.SetArgs([]string{"lint", "--all"})
+		defer func() { lintAll = false }()
+
+		var err error
+		output := captureOutput(func() {
+			err = cmd.Execute()
+		})
+		require.Error(t, err)
+
+		assert.Contains(t, output, `[laptop] .vimrc:1:`)
+		assert.Contains(t, output, `[server] .gitconfig:2:19: no key "emial" in .root`)
+		assert.Contains(t, output, `[server] .work##fork.server:1:10: no key "server_only" in .root`)
+		assert.NotContains(t, output, `[laptop] .gitconfig`)
+	})
+
+	t.Run("Encrypted Templates", func(t *testing.T) {
+		keyFile := filepath.Join(rootDir, "key")
+		viper.Set("scadufax.key_file", keyFile)
+		defer viper.Set("scadufax.key_file", "")
+		key, err := crypt.LoadOrCreateKey(keyFile)
+		require.NoError(t, err)
+		data, err := crypt.Encrypt(key, []byte("token = {{ .root.tokn }}\n"))
+		require.NoError(t, err)
+		err = os.WriteFile(filepath.Join(localDir, ".npmrc"), data, 0600)
+		require.NoError(t, err)
+		_, err = w.Add(".npmrc")
+		require.NoError(t, err)
+		_, err = w.Commit("Add npmrc", &git.CommitOptions{Author: &object.Signature{Name: "T", Email: "t", When: time.Now()}})
+		require.NoError(t, err)
+
+		cmd := rootCmd
+		cmd.SetArgs([]string{"lint"})
+		output := captureOutput(func() {
+			err = cmd.Execute()
+		})
+		require.Error(t, err)
+		assert.Contains(t, output, `.npmrc:1:18: no key "tokn" in .root`)
+	})
+}
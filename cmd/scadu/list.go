@@ -10,6 +10,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/suderio/scadufax/pkg/gitops"
+	"github.com/suderio/scadufax/pkg/processor"
 )
 
 var listAll bool
@@ -34,37 +35,55 @@ var listCmd = &cobra.Command{
 		}
 
 		ignorePatterns := viper.GetStringSlice("root.ignore")
-		red := color.New(color.FgRed).SprintFunc()
+		filter, err := fileFilter(localDir)
+		if err != nil {
+			return err
+		}
+		alts, err := findAlternates(localDir, templateSuffix())
+		if err != nil {
+			return err
+		}
+		var records []fileStatus
 
 		// 1. List files in Main
-		err := filepath.WalkDir(localDir, func(path string, d fs.DirEntry, err error) error {
+		err = filepath.WalkDir(localDir, func(path string, d fs.DirEntry, err error) error {
 			if err != nil {
 				return err
 			}
+			rel, err := filepath.Rel(localDir, path)
+			if err != nil {
+				return err
+			}
+
 			if d.IsDir() {
-				if d.Name() == ".git" {
+				if d.Name() == ".git" || isMetaPath(rel) {
 					return filepath.SkipDir
 				}
 				return nil
 			}
 
-			rel, err := filepath.Rel(localDir, path)
-			if err != nil {
-				return err
-			}
-
 			// Should we respect ignore patterns for Main files?
 			// Usually yes, but if it is in Repo, it is tracked.
 			// Ignores usually apply to what we verify/copy.
 			// Let's assume checked in files are valid content.
 
-			homePath := filepath.Join(homeDir, rel)
+			// Neither are alternates not selected for this machine, nor
+			// files the manifest excludes
+			target, ok := alts.Target(filepath.ToSlash(processor.TargetName(rel, templateSuffix())))
+			if !ok {
+				return nil
+			}
+			if ok, err := filter(target); err != nil || !ok {
+				return err
+			}
+
+			homePath := filepath.Join(homeDir, filepath.FromSlash(target))
 
+			record := fileStatus{Path: target, Status: "MANAGED", Branch: "main", Source: describe(path, isSymlink(path)), Target: describe(homePath, false)}
 			if _, err := os.Stat(homePath); os.IsNotExist(err) {
-				fmt.Printf("%s   %s\n", red("MISSING"), homePath)
-			} else {
-				fmt.Printf("          %s\n", homePath)
+				record.Status = "MISSING"
 			}
+			records = append(records, record)
 			return nil
 		})
 		if err != nil {
@@ -93,9 +112,9 @@ var listCmd = &cobra.Command{
 					return nil
 				}
 
-				repoPath := filepath.Join(localDir, rel)
+				repoPath := filepath.Join(localDir, repoRelFor(localDir, rel))
 				if _, err := os.Stat(repoPath); os.IsNotExist(err) {
-					fmt.Printf("%s %s\n", red("UNMANAGED"), path)
+					records = append(records, fileStatus{Path: filepath.ToSlash(rel), Status: "UNMANAGED", Branch: "main", Target: describe(path, false)})
 				}
 
 				return nil
@@ -105,6 +124,21 @@ var listCmd = &cobra.Command{
 			}
 		}
 
+		if output != outputText {
+			return writeRecords(records)
+		}
+		red := color.New(color.FgRed).SprintFunc()
+		for _, r := range records {
+			homePath := filepath.Join(homeDir, filepath.FromSlash(r.Path))
+			switch r.Status {
+			case "MISSING":
+				fmt.Printf("%s   %s\n", red(r.Status), homePath)
+			case "UNMANAGED":
+				fmt.Printf("%s %s\n", red(r.Status), homePath)
+			default:
+				fmt.Printf("          %s\n", homePath)
+			}
+		}
 		return nil
 	},
 }
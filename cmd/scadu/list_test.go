@@ -1,8 +1,10 @@
 package main
 
 import (
+	"encoding/json"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,6 +14,7 @@ import (
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/suderio/scadufax/pkg/state"
 )
 
 func TestListCommand_Integration(t *testing.T) {
@@ -124,4 +127,50 @@ func TestListCommand_Integration(t *testing.T) {
 		assert.Contains(t, output, "UNMANAGED")
 		assert.Contains(t, output, unmanagedRel)
 	})
+
+	t.Run("List_NDJSON", func(t *testing.T) {
+		listAll = true
+		rootCmd.SetArgs([]string{"list", "--all", "--output", "ndjson"})
+		defer rootCmd.PersistentFlags().Set("output", "text")
+
+		output := captureOutput(func() {
+			require.NoError(t, rootCmd.Execute())
+		})
+		listAll = false
+
+		var records []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+			var record map[string]any
+			require.NoError(t, json.Unmarshal([]byte(line), &record), line)
+			records = append(records, record)
+		}
+		require.Len(t, records, 2)
+		assert.Equal(t, fRel, records[0]["path"])
+		assert.Equal(t, "MANAGED", records[0]["status"])
+		assert.Equal(t, "main", records[0]["branch"])
+		assert.Equal(t, state.Sum([]byte("content")), records[0]["source"].(map[string]any)["sha256"])
+		assert.Equal(t, float64(len("content")), records[0]["target"].(map[string]any)["size"])
+		assert.Equal(t, "unmanaged.txt", records[1]["path"])
+		assert.Equal(t, "UNMANAGED", records[1]["status"])
+		assert.NotContains(t, records[1], "source")
+	})
+
+	t.Run("List_Excluded_By_Manifest", func(t *testing.T) {
+		manifestPath := filepath.Join(localDir, ".scadufax", "manifest.toml")
+		os.MkdirAll(filepath.Dir(manifestPath), 0755)
+		os.WriteFile(manifestPath, []byte("[[file]]\npath = \".config/app\"\nwhen = 'eq .sys.fork \"other\"'\n"), 0644)
+		w.Add(".scadufax/manifest.toml")
+		w.Commit("Add manifest", &git.CommitOptions{Author: &object.Signature{Name: "T", Email: "t", When: time.Now()}})
+
+		cmd := rootCmd
+		listAll = false
+		cmd.SetArgs([]string{"list"})
+
+		output := captureOutput(func() {
+			err := cmd.Execute()
+			require.NoError(t, err)
+		})
+
+		assert.NotContains(t, output, fRel)
+	})
 }
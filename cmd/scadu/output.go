@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/suderio/scadufax/pkg/manifest"
+)
+
+// outputFormat is how check, list and update write what they find.
+type outputFormat string
+
+const (
+	// outputText is for people: one colored line per file.
+	outputText outputFormat = "text"
+	// outputJSON is a single JSON array of records.
+	outputJSON outputFormat = "json"
+	// outputNDJSON is one JSON record per line.
+	outputNDJSON outputFormat = "ndjson"
+)
+
+// output is the format set with --output.
+var output = outputText
+
+func (f *outputFormat) String() string { return string(*f) }
+
+func (f *outputFormat) Set(s string) error {
+	switch format := outputFormat(s); format {
+	case outputText, outputJSON, outputNDJSON:
+		*f = format
+		return nil
+	}
+	return fmt.Errorf("must be one of %s, %s or %s", outputText, outputJSON, outputNDJSON)
+}
+
+func (f *outputFormat) Type() string { return "format" }
+
+// messages returns where commands write progress, warnings and prompts:
+// stdout, unless it carries records.
+func messages() io.Writer {
+	if output == outputText {
+		return os.Stdout
+	}
+	return os.Stderr
+}
+
+// fileStatus is a record of how a file, or a directory, differs between a
+// branch of the repository, the source, and home, the target.
+type fileStatus struct {
+	// Path is its path relative to home, slash separated.
+	Path string `json:"path"`
+	// Status is the letter check prints for it, or, for list, MISSING,
+	// MANAGED or UNMANAGED.
+	Status string `json:"status"`
+	// Branch is the branch the source comes from, rendered for main.
+	Branch string `json:"branch"`
+	Dir    bool   `json:"dir,omitempty"`
+	// Source and Target describe each side, unless it is missing.
+	Source *fileContent `json:"source,omitempty"`
+	Target *fileContent `json:"target,omitempty"`
+
+	// rel is the path of the source relative to the repository.
+	rel string
+	// dir is the manifest entry of a directory.
+	dir manifest.Dir
+}
+
+// fileContent is one side of a fileStatus.
+type fileContent struct {
+	// SHA256 is the hash of what it holds once installed, as recorded in
+	// the state; empty when it cannot be read, e.g. without the key.
+	SHA256 string `json:"sha256,omitempty"`
+	// Size is the size of the file on disk.
+	Size int64 `json:"size"`
+}
+
+// describe returns the fileContent of the file at path, hashing where it
+// points when asLink, or nil if it is missing.
+func describe(path string, asLink bool) *fileContent {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil
+	}
+	c := &fileContent{Size: info.Size()}
+	if !info.IsDir() {
+		c.SHA256, _ = installedSum(path, asLink)
+	}
+	return c
+}
+
+// writeRecords writes records to stdout in the structured format set with
+// --output. Commands print text themselves.
+func writeRecords(records []fileStatus) error {
+	switch output {
+	case outputJSON:
+		if records == nil {
+			records = []fileStatus{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case outputNDJSON:
+		enc := json.NewEncoder(os.Stdout)
+		for _, r := range records {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.PersistentFlags().Var(&output, "output", "output format of check, list and update: text, json or ndjson")
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/suderio/scadufax/pkg/manifest"
+	"github.com/suderio/scadufax/pkg/processor"
+)
+
+// loadManifest loads the manifest of the repository at repoDir.
+func loadManifest(repoDir string) (*manifest.Manifest, error) {
+	return manifest.Load(filepath.Join(repoDir, processor.MetaDir, manifest.FileName))
+}
+
+// manifestModes returns the options giving the files of the repository at
+// repoDir the modes its manifest sets, and creating the directories it
+// lists that filter keeps.
+func manifestModes(repoDir string, filter processor.Filter) ([]processor.Option, error) {
+	m, err := loadManifest(repoDir)
+	if err != nil {
+		return nil, err
+	}
+	dirs := map[string]fs.FileMode{}
+	for _, d := range m.Dirs {
+		ok, err := filter(d.Path)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			dirs[d.Path] = d.Mode()
+		}
+	}
+	return []processor.Option{processor.WithPerms(m.Perm), processor.WithDirs(dirs)}, nil
+}
+
+// permDrift reports whether the file installed as target at path lacks the
+// mode the manifest m sets for it.
+func permDrift(m *manifest.Manifest, target, path string) bool {
+	if m == nil {
+		return false
+	}
+	mode, ok := m.Perm(target)
+	return ok && !processor.HasPerm(path, mode)
+}
+
+// applyPerm gives the file installed as target at path the mode the
+// manifest m sets for it, if any.
+func applyPerm(m *manifest.Manifest, target, path string) error {
+	mode, ok := m.Perm(target)
+	if !ok || processor.HasPerm(path, mode) {
+		return nil
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		return fmt.Errorf("failed to set mode of %s: %w", path, err)
+	}
+	return nil
+}
+
+// dirChange is a directory of the manifest that home lacks ("N"), or has
+// with another mode ("P").
+type dirChange struct {
+	Dir    manifest.Dir
+	Status string
+}
+
+// changedDirs returns how the directories the manifest m lists, and filter
+// keeps, differ in homeDir.
+func changedDirs(homeDir string, m *manifest.Manifest, filter processor.Filter) ([]dirChange, error) {
+	var changes []dirChange
+	for _, d := range m.Dirs {
+		if ok, err := filter(d.Path); err != nil || !ok {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		info, err := os.Stat(filepath.Join(homeDir, filepath.FromSlash(d.Path)))
+		switch {
+		case os.IsNotExist(err):
+			changes = append(changes, dirChange{d, "N"})
+		case err != nil:
+			return nil, err
+		case !info.IsDir():
+			return nil, fmt.Errorf("%s is not a directory", d.Path)
+		case info.Mode().Perm() != d.Mode():
+			changes = append(changes, dirChange{d, "P"})
+		}
+	}
+	return changes, nil
+}
+
+// installDir creates d under homeDir with its mode.
+func installDir(homeDir string, d manifest.Dir) error {
+	path := filepath.Join(homeDir, filepath.FromSlash(d.Path))
+	if err := os.MkdirAll(path, d.Mode()); err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	if err := os.Chmod(path, d.Mode()); err != nil {
+		return fmt.Errorf("failed to set mode of %s: %w", path, err)
+	}
+	return nil
+}
+
+// installParentDirs creates the directories of the manifest m holding the
+// file installed as target, with their modes.
+func installParentDirs(homeDir string, m *manifest.Manifest, target string) error {
+	for _, d := range m.Dirs {
+		if strings.HasPrefix(target, d.Path+"/") {
+			if err := installDir(homeDir, d); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/spf13/viper"
+	"github.com/suderio/scadufax/pkg/processor"
+)
+
+// configDir returns the directory holding config.toml and local.toml.
+func configDir() string {
+	if dir := viper.GetString("scadufax.config_dir"); dir != "" {
+		return dir
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".config", "scadufax")
+}
+
+// promptMissing asks for every value the templates under repoDir read and
+// data lacks, sets the answers in data and saves them to the local.toml in
+// cfgDir, so the next run does not ask again.
+func promptMissing(repoDir, cfgDir string, data map[string]any, opts []processor.Option) error {
+	prompts, err := processor.FindPrompts(repoDir, data, opts...)
+	if err != nil {
+		return err
+	}
+	if len(prompts) == 0 {
+		return nil
+	}
+
+	answers := map[string]any{}
+	for _, p := range prompts {
+		answer, err := ask(os.Stdin, p)
+		if err != nil {
+			return err
+		}
+		answers[p.Key] = answer
+		setKey(data, p.Key, answer)
+		viper.Set(p.Key, answer)
+	}
+
+	localPath := filepath.Join(cfgDir, "local.toml")
+	if err := saveLocal(localPath, answers); err != nil {
+		return err
+	}
+	fmt.Printf("Saved %d answer(s) to %s.\n", len(prompts), localPath)
+	return nil
+}
+
+// ask asks for p on in until it gets a valid answer: a string, or a bool
+// for a PromptBool.
+func ask(in io.Reader, p processor.Prompt) (any, error) {
+	question := p.Text
+	switch p.Kind {
+	case processor.PromptBool:
+		question += " (y/n)"
+	case processor.PromptChoice:
+		for i, choice := range p.Choices {
+			fmt.Printf("  [%d] %s\n", i+1, choice)
+		}
+	}
+	if p.Default != "" {
+		question += fmt.Sprintf(" [%s]", p.Default)
+	}
+
+	for {
+		fmt.Printf("%s: ", question)
+		line, err := readLine(in)
+		if err != nil {
+			return nil, fmt.Errorf("no answer for %s: %w", p.Key, err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			line = p.Default
+		}
+		if line == "" {
+			continue
+		}
+
+		switch p.Kind {
+		case processor.PromptBool:
+			switch strings.ToLower(line) {
+			case "y", "yes", "true":
+				return true, nil
+			case "n", "no", "false":
+				return false, nil
+			}
+			fmt.Println("Please answer y or n.")
+		case processor.PromptChoice:
+			if n, err := strconv.Atoi(line); err == nil && n >= 1 && n <= len(p.Choices) {
+				return p.Choices[n-1], nil
+			}
+			for _, choice := range p.Choices {
+				if line == choice {
+					return choice, nil
+				}
+			}
+			fmt.Printf("Please pick one of %s.\n", strings.Join(p.Choices, ", "))
+		default:
+			return line, nil
+		}
+	}
+}
+
+// setKey sets the dotted path key of data to value, creating tables on the
+// way.
+func setKey(data map[string]any, key string, value any) {
+	parts := strings.Split(key, ".")
+	for _, part := range parts[:len(parts)-1] {
+		table, ok := data[part].(map[string]any)
+		if !ok {
+			table = map[string]any{}
+			data[part] = table
+		}
+		data = table
+	}
+	data[parts[len(parts)-1]] = value
+}
+
+// saveLocal adds answers, keyed by dotted path, to the TOML file at path,
+// creating it if needed. Each key goes at the end of its table, or of a new
+// one at the end of the file; everything else, comments and order included,
+// is kept as it was, and so is the file's mode.
+func saveLocal(path string, answers map[string]any) error {
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	mode := fs.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	text := string(content)
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	lines := strings.SplitAfter(text, "\n")
+	lines = lines[:len(lines)-1]
+
+	keys := make([]string, 0, len(answers))
+	for key := range answers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		table, name := "", key
+		if i := strings.LastIndex(key, "."); i >= 0 {
+			table, name = key[:i], key[i+1:]
+		}
+		line, err := toml.Marshal(map[string]any{name: answers[key]})
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", key, err)
+		}
+		lines = insertKey(lines, table, string(line))
+	}
+
+	// Whatever the file held, it must still parse
+	out := strings.Join(lines, "")
+	if err := toml.Unmarshal([]byte(out), &map[string]any{}); err != nil {
+		return fmt.Errorf("failed to add answers to %s: %w", path, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".local-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(out); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// insertKey adds line, a key and its value, to table in the TOML file held
+// in lines: after its last key, before the first table for the top level,
+// or in a new table at the end.
+func insertKey(lines []string, table, line string) []string {
+	start, end := 0, len(lines)
+	found := table == ""
+	for i, l := range lines {
+		name, ok := tableHeader(l)
+		if !ok {
+			continue
+		}
+		if found {
+			end = i
+			break
+		}
+		if name == table {
+			start, found = i+1, true
+		}
+	}
+	if !found {
+		if len(lines) > 0 {
+			lines = append(lines, "\n")
+		}
+		return append(lines, "["+table+"]\n", line)
+	}
+
+	// Blank lines stay between the table and the next one
+	for end > start && strings.TrimSpace(lines[end-1]) == "" {
+		end--
+	}
+	return append(lines[:end], append([]string{line}, lines[end:]...)...)
+}
+
+// tableHeader returns the name of the table line opens, with its keys
+// unquoted, or "" for an array of tables. ok is false for other lines.
+func tableHeader(line string) (name string, ok bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "[") {
+		return "", false
+	}
+	if strings.HasPrefix(line, "[[") {
+		return "", true
+	}
+	end := strings.Index(line, "]")
+	if end < 0 {
+		return "", false
+	}
+	parts := strings.Split(line[1:end], ".")
+	for i, part := range parts {
+		parts[i] = strings.Trim(strings.TrimSpace(part), `"'`)
+	}
+	return strings.Join(parts, "."), true
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/suderio/scadufax/pkg/crypt"
+	"github.com/suderio/scadufax/pkg/gitops"
+	"github.com/suderio/scadufax/pkg/manifest"
+	"github.com/suderio/scadufax/pkg/processor"
+)
+
+var reAddCmd = &cobra.Command{
+	Use:   "re-add [file]...",
+	Short: "Carry changes made in home back into the templates",
+	Long: `Re-add compares each home file against the version the fork branch holds,
+and applies the difference to the template on the main branch.
+
+Changes to lines copied verbatim from the template are applied as is. Changes
+touching lines a template action produced are left between conflict markers
+and opened in the editor; re-add fails if markers remain once it is closed.
+The template is then committed with a SCADUFAX_ID. Run 'scadu update' once the
+pipeline has reified it to bring the fork up to date.
+
+The pre_re_add and post_re_add hooks run before and after, with the files given.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		localDir := viper.GetString("scadufax.local_dir")
+		if localDir == "" {
+			home, _ := os.UserHomeDir()
+			localDir = filepath.Join(home, ".local", "share", "scadufax")
+		}
+		homeDir := viper.GetString("scadufax.home_dir")
+		if homeDir == "" {
+			homeDir, _ = os.UserHomeDir()
+		}
+
+		homePaths := absPaths(args)
+		if err := runHook("pre_re_add", homePaths, ""); err != nil {
+			return err
+		}
+
+		var commitID string
+		for _, arg := range args {
+			absPath, err := filepath.Abs(arg)
+			if err != nil {
+				return fmt.Errorf("failed to get abs path for %s: %w", arg, err)
+			}
+			if !strings.HasPrefix(absPath, homeDir) {
+				return fmt.Errorf("file %s is not in home directory %s", arg, homeDir)
+			}
+			rel, err := filepath.Rel(homeDir, absPath)
+			if err != nil {
+				return fmt.Errorf("failed to get relative path: %w", err)
+			}
+			id, err := reAdd(localDir, homeDir, rel)
+			if err != nil {
+				return err
+			}
+			if id != "" {
+				commitID = id
+			}
+		}
+		fmt.Println("Done.")
+		postHook("post_re_add", homePaths, commitID)
+		return nil
+	},
+}
+
+// reAdd carries the changes made to the home file rel since it was installed
+// from the fork into its template on main, and commits it. It returns the
+// SCADUFAX_ID of the commit, or "" if there was nothing to commit.
+func reAdd(localDir, homeDir, rel string) (string, error) {
+	forkName := resolvedFork()
+	if err := gitops.Checkout(localDir, forkName); err != nil {
+		return "", fmt.Errorf("failed to checkout fork branch %s: %w", forkName, err)
+	}
+	forkPath := filepath.Join(localDir, repoRelFor(localDir, rel))
+	if _, err := os.Stat(forkPath); os.IsNotExist(err) {
+		return "", fmt.Errorf("%s is not in fork %s; use 'scadu add' for new files", rel, forkName)
+	}
+	rendered, _, err := readDecrypted(forkPath)
+	if err != nil {
+		return "", err
+	}
+	edited, err := os.ReadFile(filepath.Join(homeDir, rel))
+	if err != nil {
+		return "", err
+	}
+
+	if err := gitops.Checkout(localDir, "main"); err != nil {
+		return "", fmt.Errorf("failed to checkout main: %w", err)
+	}
+	if bytes.Equal(rendered, edited) {
+		fmt.Printf("No changes in %s.\n", rel)
+		return "", nil
+	}
+
+	repoRel := repoRelFor(localDir, rel)
+	repoPath := filepath.Join(localDir, repoRel)
+	tmpl, err := os.ReadFile(repoPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read template of %s: %w", rel, err)
+	}
+	if crypt.IsEncrypted(tmpl) {
+		return "", fmt.Errorf("%s is encrypted; use 'scadu edit' instead", repoRel)
+	}
+	binary, err := processor.IsBinary(repoPath)
+	if err != nil {
+		return "", err
+	}
+	if binary {
+		return "", fmt.Errorf("%s is binary; use 'scadu edit' instead", repoRel)
+	}
+
+	merged := edited
+	conflicts := 0
+	if processor.IsTemplate(repoPath, templateSuffix()) {
+		rules, err := manifest.Load(filepath.Join(localDir, processor.MetaDir, manifest.FileName))
+		if err != nil {
+			return "", err
+		}
+		left, right := rules.Delims(filepath.ToSlash(rel))
+		merged, conflicts = processor.ReAdd(tmpl, rendered, edited, left, right)
+	}
+	if bytes.Equal(merged, tmpl) {
+		fmt.Printf("No changes to carry over to %s.\n", repoRel)
+		return "", nil
+	}
+
+	info, err := os.Stat(repoPath)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(repoPath, merged, info.Mode()); err != nil {
+		return "", err
+	}
+
+	if conflicts > 0 {
+		fmt.Printf("%s has %d conflict(s) with template actions. Opening the editor...\n", repoRel, conflicts)
+		if err := resolveConflicts(repoPath); err != nil {
+			// Leave the template as it was
+			if restoreErr := os.WriteFile(repoPath, tmpl, info.Mode()); restoreErr != nil {
+				return "", fmt.Errorf("%w (restoring %s failed: %v)", err, repoRel, restoreErr)
+			}
+			return "", err
+		}
+	}
+
+	fmt.Printf("Committing %s...\n", repoRel)
+	id, err := commitFile(localDir, repoRel, fmt.Sprintf("Update %s via scadu re-add", repoRel))
+	if err != nil {
+		return "", fmt.Errorf("failed to commit %s: %w", repoRel, err)
+	}
+	return id, nil
+}
+
+// resolveConflicts opens path in the editor and checks that no conflict
+// marker is left once it is closed.
+func resolveConflicts(path string) error {
+	editor, err := resolveEditor()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(editor, path)
+	cmd.Env = os.Environ()
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor exited with error: %w", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		switch line {
+		case processor.ConflictStart, processor.ConflictSep, processor.ConflictEnd:
+			return fmt.Errorf("%s still has conflict markers; template left unchanged", path)
+		}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(reAddCmd)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReAddCommand_Integration(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping shell script mock editor test on Windows")
+	}
+
+	rootDir := setupTestDir(t)
+	homeDir := filepath.Join(rootDir, "home")
+	localDir := filepath.Join(rootDir, "local")
+	require.NoError(t, os.MkdirAll(homeDir, 0755))
+	require.NoError(t, os.MkdirAll(localDir, 0755))
+
+	viper.Reset()
+	viper.Set("scadufax.local_dir", localDir)
+	viper.Set("scadufax.home_dir", homeDir)
+	viper.Set("scadufax.fork", "testfork")
+
+	repo, err := git.PlainInit(localDir, false)
+	require.NoError(t, err)
+	w, err := repo.Worktree()
+	require.NoError(t, err)
+	err = repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.ReferenceName("refs/heads/main")))
+	require.NoError(t, err)
+
+	commit := func(msg string) {
+		_, err := w.Add(".")
+		require.NoError(t, err)
+		_, err = w.Commit(msg, &git.CommitOptions{
+			Author: &object.Signature{Name: "Test", Email: "test@local", When: time.Now()},
+		})
+		require.NoError(t, err)
+	}
+
+	// Templates on main
+	gitconfig := "[user]\n\tname = {{ .name }}\n\temail = me@example.com\n[core]\n\teditor = vim\n"
+	aliases := "alias ll='ls -l'\nalias g={{ .git }}\n"
+	require.NoError(t, os.WriteFile(filepath.Join(localDir, ".gitconfig"), []byte(gitconfig), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(localDir, ".aliases"), []byte(aliases), 0644))
+	commit("Initial main")
+
+	// Their reified versions on the fork
+	err = w.Checkout(&git.CheckoutOptions{Branch: plumbing.ReferenceName("refs/heads/testfork"), Create: true})
+	require.NoError(t, err)
+	forkGitconfig := "[user]\n\tname = Bob\n\temail = me@example.com\n[core]\n\teditor = vim\n"
+	forkAliases := "alias ll='ls -l'\nalias g=git\n"
+	require.NoError(t, os.WriteFile(filepath.Join(localDir, ".gitconfig"), []byte(forkGitconfig), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(localDir, ".aliases"), []byte(forkAliases), 0644))
+	commit("Fork reified state")
+
+	// re-add leaves the repository on main
+	headCommit := func() *object.Commit {
+		ref, err := repo.Head()
+		require.NoError(t, err)
+		c, err := repo.CommitObject(ref.Hash())
+		require.NoError(t, err)
+		return c
+	}
+
+	t.Run("Literal lines are carried over", func(t *testing.T) {
+		home := "[user]\n\tname = Bob\n\temail = bob@example.com\n[core]\n\teditor = nvim\n\tpager = less\n"
+		homePath := filepath.Join(homeDir, ".gitconfig")
+		require.NoError(t, os.WriteFile(homePath, []byte(home), 0644))
+
+		// A failing pre_re_add hook stops it
+		viper.Set("hooks.pre_re_add", "exit 1")
+		before := headCommit().Hash
+		rootCmd.SetArgs([]string{"re-add", homePath})
+		err := rootCmd.Execute()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "pre_re_add hook")
+		assert.Equal(t, before, headCommit().Hash)
+
+		log := filepath.Join(rootDir, "hooks.log")
+		viper.Set("hooks.pre_re_add", nil)
+		viper.Set("hooks.post_re_add", `echo "$SCADUFAX_HOOK $SCADUFAX_PATHS $SCADUFAX_ID" >> `+log)
+		defer viper.Set("hooks.post_re_add", nil)
+		rootCmd.SetArgs([]string{"re-add", homePath})
+		require.NoError(t, rootCmd.Execute())
+
+		c := headCommit()
+		assert.Contains(t, c.Message, "Update .gitconfig via scadu re-add")
+		assert.Contains(t, c.Message, "SCADUFAX_ID:")
+		id := strings.TrimSpace(c.Message[strings.Index(c.Message, "SCADUFAX_ID: ")+len("SCADUFAX_ID: "):])
+		content, err := os.ReadFile(log)
+		require.NoError(t, err)
+		assert.Equal(t, "post_re_add "+homePath+" "+id+"\n", string(content))
+
+		content, err = os.ReadFile(filepath.Join(localDir, ".gitconfig"))
+		require.NoError(t, err)
+		assert.Equal(t, "[user]\n\tname = {{ .name }}\n\temail = bob@example.com\n[core]\n\teditor = nvim\n\tpager = less\n", string(content))
+
+		// Nothing to commit, so no ID to hand over, not the last one
+		require.NoError(t, os.Remove(log))
+		require.NoError(t, os.WriteFile(homePath, []byte(forkGitconfig), 0644))
+		rootCmd.SetArgs([]string{"re-add", homePath})
+		require.NoError(t, rootCmd.Execute())
+		content, err = os.ReadFile(log)
+		require.NoError(t, err)
+		assert.Equal(t, "post_re_add "+homePath+" \n", string(content))
+	})
+
+	t.Run("Conflicts are resolved in the editor", func(t *testing.T) {
+		homePath := filepath.Join(homeDir, ".aliases")
+		require.NoError(t, os.WriteFile(homePath, []byte("alias ll='ls -la'\nalias g=hub\n"), 0644))
+
+		// The editor keeps the template's side of the conflict
+		editor := filepath.Join(rootDir, "resolve.sh")
+		script := "#!/bin/sh\nsed -i -e '/^=======$/,/^>>>>>>> home$/d' -e '/^<<<<<<< template$/d' \"$1\"\n"
+		require.NoError(t, os.WriteFile(editor, []byte(script), 0755))
+		os.Setenv("EDITOR", editor)
+		defer os.Unsetenv("EDITOR")
+
+		rootCmd.SetArgs([]string{"re-add", homePath})
+		require.NoError(t, rootCmd.Execute())
+
+		assert.Contains(t, headCommit().Message, "Update .aliases via scadu re-add")
+		content, err := os.ReadFile(filepath.Join(localDir, ".aliases"))
+		require.NoError(t, err)
+		assert.Equal(t, "alias ll='ls -la'\nalias g={{ .git }}\n", string(content))
+	})
+
+	t.Run("Unresolved conflicts leave the template alone", func(t *testing.T) {
+		homePath := filepath.Join(homeDir, ".aliases")
+		require.NoError(t, os.WriteFile(homePath, []byte("alias ll='ls -l'\nalias g=tig\n"), 0644))
+
+		os.Setenv("EDITOR", "true")
+		defer os.Unsetenv("EDITOR")
+
+		before := headCommit().Hash
+		rootCmd.SetArgs([]string{"re-add", homePath})
+		err := rootCmd.Execute()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "conflict markers")
+
+		assert.Equal(t, before, headCommit().Hash)
+		content, err := os.ReadFile(filepath.Join(localDir, ".aliases"))
+		require.NoError(t, err)
+		assert.Equal(t, "alias ll='ls -la'\nalias g={{ .git }}\n", string(content))
+	})
+}
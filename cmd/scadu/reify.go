@@ -6,7 +6,7 @@ import (
 	"path/filepath"
 
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
+	"github.com/suderio/scadufax/pkg/crypt"
 	"github.com/suderio/scadufax/pkg/processor"
 )
 
@@ -17,7 +17,17 @@ var reifyCmd = &cobra.Command{
 	Long: `Applies the values found in the configuration files to all the files in the directory, recursively.
 
 Values are read from config.toml and local.toml in ~/.config/scadufax/.
-If --secret is passed, values for the secret command are read from .env in the target directory.`,
+Files in .scadufax/templates/ are available to every file as named templates
+and are not rendered themselves.
+If --secret is passed, values for the secret command are read from .env in the target directory,
+or from the backends configured under [secrets].
+If --seal is passed, files that used secrets (and encrypted files) are sealed to the public
+key of the fork, .scadufax/recipients/<fork>.pub, so only that machine can read them.
+Without --seal, encrypted files are kept encrypted in place, and an encrypted template
+is an error.
+If --out is passed, the directory is reified into another one and left untouched.
+If --prompt is passed, every value the templates read that is not configured yet is
+asked for first, and the answers are saved to local.toml.`,
 	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		targetPath := args[0]
@@ -29,7 +39,7 @@ If --secret is passed, values for the secret command are read from .env in the t
 		}
 
 		// Prepare data
-		data := viper.AllSettings()
+		data := templateData()
 
 		// Get flag value
 		useSecret, err := cmd.Flags().GetBool("secret")
@@ -38,7 +48,7 @@ If --secret is passed, values for the secret command are read from .env in the t
 		}
 
 		// Prepare secret handler
-		var secretFn func(string) (string, error)
+		var secretFn processor.SecretFunc
 
 		if useSecret {
 			// Load .env from the target directory (or parent if target is a file)
@@ -48,25 +58,117 @@ If --secret is passed, values for the secret command are read from .env in the t
 			}
 			envPath := filepath.Join(envDir, ".env")
 
-			// Requirement: If --secret is true, we strictly verify .env exists.
-			var err error
-			secretFn, err = processor.GetSecretFn(envPath, true)
+			// With --secret the .env must exist, once the dotenv backend
+			// is used: other backends need none
+			registry, err := newSecrets(envPath, true)
 			if err != nil {
 				return err
 			}
+			secretFn = registry.Lookup
 		} else {
 			// Default behavior: preserve template tags
-			secretFn = func(key string) (string, error) {
-				return fmt.Sprintf("{{ %q | secret }}", key), nil
+			secretFn = preserveSecret
+		}
+
+		// Shared templates live in the target directory itself
+		// Encrypted files are only decrypted where the key is available
+		key, err := loadKey()
+		if err != nil {
+			return err
+		}
+		opts := []processor.Option{
+			processor.WithTemplateSuffix(templateSuffix()),
+			processor.WithKey(key),
+			processor.WithMachine(machine()),
+			renderCache(),
+		}
+
+		// Seal sensitive output to the machine the fork belongs to
+		seal, err := cmd.Flags().GetBool("seal")
+		if err != nil {
+			return err
+		}
+		if seal {
+			if !info.IsDir() {
+				return fmt.Errorf("--seal requires a directory")
+			}
+			recipient, err := crypt.LoadPublicKey(recipientPath(targetPath, resolvedFork()))
+			if err != nil {
+				return fmt.Errorf("cannot seal: %w (run 'scadu keygen' on the machine)", err)
+			}
+			opts = append(opts, processor.WithRecipient(recipient))
+		}
+		if info.IsDir() {
+			includes, err := loadIncludes(targetPath, resolvedFork())
+			if err != nil {
+				return err
+			}
+			// Files the manifest excludes for this machine are left alone
+			filter, err := fileFilter(targetPath)
+			if err != nil {
+				return err
+			}
+			delims, err := manifestDelims(targetPath)
+			if err != nil {
+				return err
+			}
+			modes, err := manifestModes(targetPath, filter)
+			if err != nil {
+				return err
+			}
+			opts = append(opts, includes, delims, processor.WithFilter(filter))
+			opts = append(opts, modes...)
+		}
+
+		// Ask for the values templates need and the configuration lacks
+		prompt, err := cmd.Flags().GetBool("prompt")
+		if err != nil {
+			return err
+		}
+		if prompt {
+			if !info.IsDir() {
+				return fmt.Errorf("--prompt requires a directory")
+			}
+			if err := promptMissing(targetPath, configDir(), data, opts); err != nil {
+				return err
+			}
+		}
+
+		renderer, err := processor.NewRenderer(processor.RendererOptions{
+			Data:    []map[string]any{data},
+			Secrets: secretFn,
+			Options: opts,
+		})
+		if err != nil {
+			return err
+		}
+
+		// Reify into another directory, leaving the templates alone
+		out, err := cmd.Flags().GetString("out")
+		if err != nil {
+			return err
+		}
+		if out != "" {
+			if !info.IsDir() {
+				return fmt.Errorf("--out requires a directory")
+			}
+			report, err := renderer.RenderTree(targetPath, out, false)
+			if err != nil {
+				return err
 			}
+			fmt.Printf("Reified %s into %s: %d file(s) changed.\n", targetPath, out, len(report.Changed()))
+			return nil
 		}
 
-		// Run Processor
-		return processor.Reify(targetPath, data, secretFn, false)
+		_, err = renderer.RenderTree(targetPath, targetPath, false)
+		return err
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(reifyCmd)
 	reifyCmd.Flags().Bool("secret", false, "enable secret processing using .env")
+	reifyCmd.Flags().Bool("seal", false, "seal sensitive files to the fork's public key")
+	reifyCmd.Flags().String("out", "", "write the reified tree to this directory instead of in place")
+	reifyCmd.Flags().Bool("prompt", false, "ask for missing template values and save them to local.toml")
 }
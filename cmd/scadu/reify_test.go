@@ -2,13 +2,17 @@ package main
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
 
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/suderio/scadufax/pkg/crypt"
+	"github.com/suderio/scadufax/pkg/processor"
 )
 
 // Helper to create temp dir and fles
@@ -43,6 +47,8 @@ func TestReifyCommand_SecretFlagBehavior(t *testing.T) {
 		expected      string
 		expectError   bool
 		errorContains string
+		settings      map[string]any
+		environ       map[string]string
 	}{
 		{
 			name:        "Secret False Preserves Tag",
@@ -78,12 +84,27 @@ func TestReifyCommand_SecretFlagBehavior(t *testing.T) {
 			expectError:   true,
 			errorContains: "secret key \"KEY\" not found",
 		},
+		{
+			name:       "Secret True Other Backend Needs No Env",
+			secretFlag: true,
+			createEnv:  false,
+			template:   `Val: {{ "KEY" | secret }}`,
+			expected:   `Val: EnvSecret`,
+			settings:   map[string]any{"secrets.default": "env"},
+			environ:    map[string]string{"KEY": "EnvSecret"},
+		},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			resetViper()
 			rootDir := setupTestDir(t)
+			for k, v := range tc.settings {
+				viper.Set(k, v)
+			}
+			for k, v := range tc.environ {
+				t.Setenv(k, v)
+			}
 
 			// Setup files
 			tmplPath := filepath.Join(rootDir, "file.txt")
@@ -167,3 +188,785 @@ func TestReifyCommand_EnvLocationStrictness(t *testing.T) {
 	content, _ := os.ReadFile(tmplPath)
 	assert.Equal(t, "RootSecret", string(content), "Should use root .env, not subdir .env")
 }
+
+func TestReifyCommand_TemplateFunctions(t *testing.T) {
+	rootDir := setupTestDir(t)
+	tmplPath := filepath.Join(rootDir, "file.txt")
+
+	template := `{{ .root.name | replace " " "-" | lower }}
+{{ get .root "missing" | default "fallback" }}
+{{ .root.empty | default "empty" }}
+{{ if hasKey .root "missing" }}present{{ else }}absent{{ end }}
+{{ coalesce "" .root.name }}
+{{ ternary "yes" "no" (eq .root.name "My Box") }}
+{{ list "a" "b" "c" | join "," }}
+{{ dict "k" "v" | toJson }}
+{{ "line1\nline2" | nindent 2 }}
+{{ regexReplaceAll "[0-9]+" "N" "v123" }}
+{{ "hello" | b64enc }}
+{{ pathJoin "a" "b" "c.txt" | pathBase }}`
+
+	expected := `my-box
+fallback
+empty
+absent
+My Box
+yes
+a,b,c
+{"k":"v"}
+
+  line1
+  line2
+vN
+aGVsbG8=
+c.txt`
+
+	err := os.WriteFile(tmplPath, []byte(template), 0644)
+	require.NoError(t, err)
+
+	resetViper()
+	viper.Set("root.name", "My Box")
+	viper.Set("root.empty", "")
+
+	cmd := rootCmd
+	cmd.SetArgs([]string{"reify", rootDir, "--secret=false"})
+	err = cmd.Execute()
+	require.NoError(t, err)
+
+	content, _ := os.ReadFile(tmplPath)
+	assert.Equal(t, expected, string(content))
+
+	// default alone does not cover a missing key
+	err = os.WriteFile(tmplPath, []byte(`{{ .root.missing | default "fallback" }}`), 0644)
+	require.NoError(t, err)
+	cmd.SetArgs([]string{"reify", rootDir, "--secret=false"})
+	err = cmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "missing")
+}
+
+func TestReifyCommand_Includes(t *testing.T) {
+	rootDir := setupTestDir(t)
+	includesDir := filepath.Join(rootDir, ".scadufax", "templates")
+	err := os.MkdirAll(filepath.Join(includesDir, "forks", "laptop"), 0755)
+	require.NoError(t, err)
+
+	// Shared partial with an overridable block
+	partial := `export PATH="$HOME/bin:$PATH"
+{{ block "aliases" . }}alias ll='ls -l'{{ end }}`
+	err = os.WriteFile(filepath.Join(includesDir, "path.sh"), []byte(partial), 0644)
+	require.NoError(t, err)
+
+	// Override of the block for the "laptop" fork only
+	override := `{{ define "aliases" }}alias ll='ls -la'{{ end }}`
+	err = os.WriteFile(filepath.Join(includesDir, "forks", "laptop", "aliases"), []byte(override), 0644)
+	require.NoError(t, err)
+
+	bashrc := filepath.Join(rootDir, ".bashrc")
+	err = os.WriteFile(bashrc, []byte(`# bash
+{{ template "path.sh" . }}`), 0644)
+	require.NoError(t, err)
+
+	zshrc := filepath.Join(rootDir, ".zshrc")
+	err = os.WriteFile(zshrc, []byte(`# zsh
+{{ include "path.sh" . | indent 2 }}`), 0644)
+	require.NoError(t, err)
+
+	t.Run("Shared", func(t *testing.T) {
+		resetViper()
+		viper.Set("scadufax.fork", "desktop")
+
+		cmd := rootCmd
+		cmd.SetArgs([]string{"reify", rootDir, "--secret=false"})
+		err := cmd.Execute()
+		require.NoError(t, err)
+
+		content, _ := os.ReadFile(bashrc)
+		assert.Equal(t, "# bash\nexport PATH=\"$HOME/bin:$PATH\"\nalias ll='ls -l'", string(content))
+
+		content, _ = os.ReadFile(zshrc)
+		assert.Equal(t, "# zsh\n  export PATH=\"$HOME/bin:$PATH\"\n  alias ll='ls -l'", string(content))
+
+		// Includes are not rendered themselves
+		content, _ = os.ReadFile(filepath.Join(includesDir, "path.sh"))
+		assert.Equal(t, partial, string(content))
+	})
+
+	t.Run("Fork Override", func(t *testing.T) {
+		err := os.WriteFile(bashrc, []byte(`{{ template "path.sh" . }}`), 0644)
+		require.NoError(t, err)
+
+		resetViper()
+		viper.Set("scadufax.fork", "laptop")
+
+		cmd := rootCmd
+		cmd.SetArgs([]string{"reify", rootDir, "--secret=false"})
+		err = cmd.Execute()
+		require.NoError(t, err)
+
+		content, _ := os.ReadFile(bashrc)
+		assert.Equal(t, "export PATH=\"$HOME/bin:$PATH\"\nalias ll='ls -la'", string(content))
+	})
+
+	t.Run("Fork Override Of A Block In The File", func(t *testing.T) {
+		override := `{{ define "prompt" }}PS1='laptop> '{{ end }}`
+		err := os.WriteFile(filepath.Join(includesDir, "forks", "laptop", "prompt"), []byte(override), 0644)
+		require.NoError(t, err)
+		kshrc := filepath.Join(rootDir, ".kshrc")
+
+		for fork, expected := range map[string]string{"desktop": "PS1='> '", "laptop": "PS1='laptop> '"} {
+			err := os.WriteFile(kshrc, []byte(`{{ block "prompt" . }}PS1='> '{{ end }}`), 0644)
+			require.NoError(t, err)
+
+			resetViper()
+			viper.Set("scadufax.fork", fork)
+
+			cmd := rootCmd
+			cmd.SetArgs([]string{"reify", rootDir, "--secret=false"})
+			require.NoError(t, cmd.Execute())
+
+			content, _ := os.ReadFile(kshrc)
+			assert.Equal(t, expected, string(content), fork)
+		}
+	})
+}
+
+func TestReifyCommand_TemplateSuffix(t *testing.T) {
+	rootDir := setupTestDir(t)
+
+	// Template: rendered and renamed
+	tmplPath := filepath.Join(rootDir, "app.conf.tmpl")
+	err := os.WriteFile(tmplPath, []byte("name = {{ .root.name }}"), 0600)
+	require.NoError(t, err)
+
+	// Plain file with literal braces: left untouched
+	chartPath := filepath.Join(rootDir, "chart", "values.yaml")
+	err = os.MkdirAll(filepath.Dir(chartPath), 0755)
+	require.NoError(t, err)
+	chart := "image: {{ .Values.image }}"
+	err = os.WriteFile(chartPath, []byte(chart), 0644)
+	require.NoError(t, err)
+
+	resetViper()
+	viper.Set("root.name", "box")
+	viper.Set("scadufax.template_suffix", ".tmpl")
+
+	cmd := rootCmd
+	cmd.SetArgs([]string{"reify", rootDir, "--secret=false"})
+	err = cmd.Execute()
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(filepath.Join(rootDir, "app.conf"))
+	require.NoError(t, err)
+	assert.Equal(t, "name = box", string(content))
+
+	info, err := os.Stat(filepath.Join(rootDir, "app.conf"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	_, err = os.Stat(tmplPath)
+	assert.True(t, os.IsNotExist(err), "template should be replaced by its output")
+
+	content, _ = os.ReadFile(chartPath)
+	assert.Equal(t, chart, string(content))
+}
+
+func TestReifyCommand_BinaryFilesUntouched(t *testing.T) {
+	rootDir := setupTestDir(t)
+
+	// Would fail to parse as a template if it were rendered
+	binary := []byte("\x89PNG\r\n\x1a\n\x00\x00{{ .broken\xff\xfe")
+	binPath := filepath.Join(rootDir, "logo.png")
+	err := os.WriteFile(binPath, binary, 0644)
+	require.NoError(t, err)
+
+	textPath := filepath.Join(rootDir, "file.txt")
+	err = os.WriteFile(textPath, []byte("{{ .root.name }}"), 0644)
+	require.NoError(t, err)
+
+	resetViper()
+	viper.Set("root.name", "box")
+
+	cmd := rootCmd
+	cmd.SetArgs([]string{"reify", rootDir, "--secret=false"})
+	err = cmd.Execute()
+	require.NoError(t, err)
+
+	content, _ := os.ReadFile(binPath)
+	assert.Equal(t, binary, content)
+
+	content, _ = os.ReadFile(textPath)
+	assert.Equal(t, "box", string(content))
+}
+
+func TestReifyCommand_SecretBackends(t *testing.T) {
+	rootDir := setupTestDir(t)
+
+	err := os.WriteFile(filepath.Join(rootDir, ".env"), []byte("FROM_DOTENV=dotenv-value"), 0600)
+	require.NoError(t, err)
+
+	t.Setenv("SCADU_TEST_TOKEN", "env-value")
+
+	tmplPath := filepath.Join(rootDir, "file.txt")
+	template := `{{ "FROM_DOTENV" | secret }}
+{{ secret "SCADU_TEST_TOKEN" "env" }}
+{{ secret "KEY" "cmd" }}
+{{ secret "SCADU_TEST_TOKEN" "chain" }}
+{{ secret "FROM_DOTENV" "chain" }}`
+	err = os.WriteFile(tmplPath, []byte(template), 0644)
+	require.NoError(t, err)
+
+	resetViper()
+	viper.Set("secrets.providers.cmd.type", "command")
+	viper.Set("secrets.providers.cmd.command", []string{"echo", "cmd-{key}"})
+	viper.Set("secrets.providers.chain.type", "chain")
+	viper.Set("secrets.providers.chain.providers", []string{"env", "dotenv"})
+
+	cmd := rootCmd
+	cmd.SetArgs([]string{"reify", rootDir, "--secret=true"})
+	err = cmd.Execute()
+	require.NoError(t, err)
+
+	content, _ := os.ReadFile(tmplPath)
+	assert.Equal(t, "dotenv-value\nenv-value\ncmd-KEY\nenv-value\ndotenv-value", string(content))
+
+	t.Run("Preserved Without Secret Flag", func(t *testing.T) {
+		err := os.WriteFile(tmplPath, []byte(`{{ secret "KEY" "cmd" }}`), 0644)
+		require.NoError(t, err)
+
+		cmd := rootCmd
+		cmd.SetArgs([]string{"reify", rootDir, "--secret=false"})
+		err = cmd.Execute()
+		require.NoError(t, err)
+
+		content, _ := os.ReadFile(tmplPath)
+		assert.Equal(t, `{{ secret "KEY" "cmd" }}`, string(content))
+	})
+
+	t.Run("Unknown Backend", func(t *testing.T) {
+		err := os.WriteFile(tmplPath, []byte(`{{ secret "KEY" "nope" }}`), 0644)
+		require.NoError(t, err)
+
+		cmd := rootCmd
+		cmd.SetArgs([]string{"reify", rootDir, "--secret=true"})
+		err = cmd.Execute()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `unknown secret backend "nope"`)
+	})
+}
+
+func TestReifyCommand_Manifest(t *testing.T) {
+	rootDir := setupTestDir(t)
+
+	manifest := `[[file]]
+path = ".config/i3"
+when = 'eq .sys.os "plan9"'
+
+[[file]]
+path = "**/*.work"
+when = 'hasPrefix "laptop-" .sys.fork'
+`
+	err := os.MkdirAll(filepath.Join(rootDir, ".scadufax"), 0755)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(rootDir, ".scadufax", "manifest.toml"), []byte(manifest), 0644)
+	require.NoError(t, err)
+
+	i3Path := filepath.Join(rootDir, ".config", "i3", "config")
+	err = os.MkdirAll(filepath.Dir(i3Path), 0755)
+	require.NoError(t, err)
+	err = os.WriteFile(i3Path, []byte("{{ .sys.os }}"), 0644)
+	require.NoError(t, err)
+	workPath := filepath.Join(rootDir, ".gitconfig.work")
+	err = os.WriteFile(workPath, []byte("{{ .sys.fork }}"), 0644)
+	require.NoError(t, err)
+
+	resetViper()
+	viper.Set("scadufax.fork", "laptop-work")
+	viper.Set("sys.os", "plan9")
+
+	cmd := rootCmd
+	cmd.SetArgs([]string{"reify", rootDir, "--secret=false"})
+	err = cmd.Execute()
+	require.NoError(t, err)
+
+	content, _ := os.ReadFile(i3Path)
+	assert.Equal(t, "plan9", string(content))
+	content, _ = os.ReadFile(workPath)
+	assert.Equal(t, "laptop-work", string(content))
+
+	t.Run("Excluded Files Are Skipped", func(t *testing.T) {
+		err := os.WriteFile(i3Path, []byte("{{ .sys.os }}"), 0644)
+		require.NoError(t, err)
+		err = os.WriteFile(workPath, []byte("{{ .sys.fork }}"), 0644)
+		require.NoError(t, err)
+
+		resetViper()
+		viper.Set("scadufax.fork", "server-01")
+
+		cmd := rootCmd
+		cmd.SetArgs([]string{"reify", rootDir, "--secret=false"})
+		err = cmd.Execute()
+		require.NoError(t, err)
+
+		content, _ := os.ReadFile(i3Path)
+		assert.Equal(t, "{{ .sys.os }}", string(content))
+		content, _ = os.ReadFile(workPath)
+		assert.Equal(t, "{{ .sys.fork }}", string(content))
+	})
+
+	t.Run("Invalid Condition", func(t *testing.T) {
+		err := os.WriteFile(filepath.Join(rootDir, ".scadufax", "manifest.toml"), []byte("[[file]]\npath = \"*\"\nwhen = \"eq (\"\n"), 0644)
+		require.NoError(t, err)
+
+		cmd := rootCmd
+		cmd.SetArgs([]string{"reify", rootDir, "--secret=false"})
+		err = cmd.Execute()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid condition for *")
+	})
+}
+
+func TestReifyCommand_Alternates(t *testing.T) {
+	rootDir := setupTestDir(t)
+
+	files := map[string]string{
+		".gitconfig##default":                   "default",
+		".gitconfig##os.plan9":                  "plan9",
+		".gitconfig##os.plan9,fork.laptop-work": "{{ .sys.fork }}",
+		".vimrc":                                "plain",
+		".vimrc##fork.server":                   "server",
+		".bashrc##os.other":                     "other",
+	}
+	for name, content := range files {
+		err := os.WriteFile(filepath.Join(rootDir, name), []byte(content), 0644)
+		require.NoError(t, err)
+	}
+
+	resetViper()
+	viper.Set("scadufax.fork", "laptop-work")
+	viper.Set("sys.os", "plan9")
+
+	cmd := rootCmd
+	cmd.SetArgs([]string{"reify", rootDir, "--secret=false"})
+	err := cmd.Execute()
+	require.NoError(t, err)
+
+	entries, err := os.ReadDir(rootDir)
+	require.NoError(t, err)
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	// Only the most specific variant is left, under the base name
+	assert.ElementsMatch(t, []string{".gitconfig", ".vimrc"}, names)
+
+	content, _ := os.ReadFile(filepath.Join(rootDir, ".gitconfig"))
+	assert.Equal(t, "laptop-work", string(content))
+	content, _ = os.ReadFile(filepath.Join(rootDir, ".vimrc"))
+	assert.Equal(t, "plain", string(content))
+}
+
+func TestReifyCommand_RenderCache(t *testing.T) {
+	rootDir := setupTestDir(t)
+	cacheDir := setupTestDir(t)
+
+	tmplPath := filepath.Join(rootDir, "file.txt")
+	template := `{{ "TOKEN" | secret }} {{ .root.name }}`
+
+	reify := func(env, name string) string {
+		err := os.WriteFile(filepath.Join(rootDir, ".env"), []byte("TOKEN="+env), 0600)
+		require.NoError(t, err)
+		err = os.WriteFile(tmplPath, []byte(template), 0644)
+		require.NoError(t, err)
+
+		resetViper()
+		viper.Set("scadufax.cache_dir", cacheDir)
+		viper.Set("root.name", name)
+
+		cmd := rootCmd
+		cmd.SetArgs([]string{"reify", rootDir, "--secret=true"})
+		require.NoError(t, cmd.Execute())
+
+		content, err := os.ReadFile(tmplPath)
+		require.NoError(t, err)
+		return string(content)
+	}
+
+	assert.Equal(t, "one box", reify("one", "box"))
+
+	// The rendered file is kept, readable by the owner only
+	var entries []string
+	err := filepath.WalkDir(cacheDir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			info, err := d.Info()
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+			entries = append(entries, path)
+		}
+		return err
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, entries)
+
+	assert.Equal(t, "one box", reify("one", "box"))
+	assert.Equal(t, "two box", reify("two", "box"), "a changed secret renders the file again")
+	assert.Equal(t, "two crate", reify("two", "crate"), "changed data renders the file again")
+
+	t.Run("Failure Leaves Tree Untouched", func(t *testing.T) {
+		good := filepath.Join(rootDir, "good.txt")
+		err := os.WriteFile(good, []byte(`{{ .root.name }}`), 0644)
+		require.NoError(t, err)
+		err = os.WriteFile(tmplPath, []byte(`{{ .root.nope }}`), 0644)
+		require.NoError(t, err)
+
+		cmd := rootCmd
+		cmd.SetArgs([]string{"reify", rootDir, "--secret=false"})
+		err = cmd.Execute()
+		require.Error(t, err)
+
+		content, _ := os.ReadFile(good)
+		assert.Equal(t, `{{ .root.name }}`, string(content))
+	})
+}
+
+func TestReifyCommand_RollsBackInterruptedReify(t *testing.T) {
+	rootDir := setupTestDir(t)
+	txnDir := filepath.Join(rootDir, processor.TxnDir)
+	require.NoError(t, os.MkdirAll(txnDir, 0700))
+
+	// A reify that crashed after moving .gitconfig aside and putting its
+	// new content in place, but before dropping the template, having
+	// created a directory for another file
+	gitconfig := filepath.Join(rootDir, ".gitconfig")
+	require.NoError(t, os.WriteFile(filepath.Join(txnDir, "1"), []byte("name = {{ .root.name }}"), 0644))
+	require.NoError(t, os.WriteFile(gitconfig, []byte("half-written"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(rootDir, ".config", "app"), 0755))
+	staged := filepath.ToSlash(processor.TxnDir)
+	journal := fmt.Sprintf(`{"dirs":[".config",".config/app"],"renames":[{"from":".gitconfig","to":"%s/1"},{"from":"%s/2","to":".gitconfig"}]}`, staged, staged)
+	require.NoError(t, os.WriteFile(filepath.Join(txnDir, "journal.json"), []byte(journal), 0600))
+
+	resetViper()
+	viper.Set("scadufax.cache_dir", setupTestDir(t))
+	viper.Set("root.name", "box")
+
+	cmd := rootCmd
+	cmd.SetArgs([]string{"reify", rootDir, "--secret=false"})
+	require.NoError(t, cmd.Execute())
+
+	content, _ := os.ReadFile(gitconfig)
+	assert.Equal(t, "name = box", string(content))
+	assert.NoDirExists(t, filepath.Join(rootDir, ".config"), "created directories are removed")
+	assert.NoDirExists(t, filepath.Join(rootDir, processor.MetaDir), "staging is cleaned up")
+
+	// A journal may only name paths in the tree
+	require.NoError(t, os.MkdirAll(txnDir, 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(txnDir, "journal.json"), []byte(`{"renames":[{"from":"../x","to":".gitconfig"}]}`), 0600))
+	cmd.SetArgs([]string{"reify", rootDir, "--secret=false"})
+	err := cmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "outside the tree")
+}
+
+func TestReifyCommand_Out(t *testing.T) {
+	srcDir := setupTestDir(t)
+	outDir := filepath.Join(setupTestDir(t), "out")
+
+	files := map[string]string{
+		".gitconfig.tmpl":            "name = {{ .root.name }}",
+		".config/app/rc##os.plan9":   "plan9",
+		".config/app/rc##os.other":   "other",
+		".vimrc":                     "{{ not rendered }}",
+		".scadufax/templates/x.tmpl": `{{ define "x" }}x{{ end }}`,
+		".config/app/settings.tmpl":  `{{ include "x" . }}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(srcDir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+
+	resetViper()
+	viper.Set("scadufax.cache_dir", setupTestDir(t))
+	viper.Set("scadufax.template_suffix", ".tmpl")
+	viper.Set("sys.os", "plan9")
+	viper.Set("root.name", "box")
+	defer reifyCmd.Flags().Set("out", "")
+
+	cmd := rootCmd
+	cmd.SetArgs([]string{"reify", srcDir, "--secret=false", "--out", outDir})
+	require.NoError(t, cmd.Execute())
+
+	// The source tree is left alone
+	content, _ := os.ReadFile(filepath.Join(srcDir, ".gitconfig.tmpl"))
+	assert.Equal(t, "name = {{ .root.name }}", string(content))
+	assert.FileExists(t, filepath.Join(srcDir, ".config", "app", "rc##os.other"))
+
+	expected := map[string]string{
+		".gitconfig":           "name = box",
+		".config/app/rc":       "plan9",
+		".config/app/settings": "x",
+		".vimrc":               "{{ not rendered }}",
+	}
+	var got []string
+	err := filepath.WalkDir(outDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(outDir, path)
+		got = append(got, filepath.ToSlash(rel))
+		return nil
+	})
+	require.NoError(t, err)
+	var want []string
+	for name, content := range expected {
+		want = append(want, name)
+		actual, err := os.ReadFile(filepath.Join(outDir, filepath.FromSlash(name)))
+		require.NoError(t, err)
+		assert.Equal(t, content, string(actual), name)
+	}
+	assert.ElementsMatch(t, want, got)
+
+	t.Run("Requires A Directory", func(t *testing.T) {
+		cmd := rootCmd
+		cmd.SetArgs([]string{"reify", filepath.Join(srcDir, ".vimrc"), "--secret=false", "--out", outDir})
+		err := cmd.Execute()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "--out requires a directory")
+	})
+}
+
+func TestReifyCommand_EncryptedInPlace(t *testing.T) {
+	srcDir := setupTestDir(t)
+	outDir := filepath.Join(setupTestDir(t), "out")
+	keyFile := filepath.Join(setupTestDir(t), "key")
+
+	key, err := crypt.LoadOrCreateKey(keyFile)
+	require.NoError(t, err)
+	encrypt := func(name, plain string) string {
+		data, err := crypt.Encrypt(key, []byte(plain))
+		require.NoError(t, err)
+		path := filepath.Join(srcDir, name)
+		require.NoError(t, os.WriteFile(path, data, 0600))
+		return path
+	}
+	secretPath := encrypt(".netrc", "password hunter2")
+
+	resetViper()
+	viper.Set("scadufax.key_file", keyFile)
+	viper.Set("root.name", "box")
+	defer reifyCmd.Flags().Set("out", "")
+
+	// Reified in place, the tree keeps the file encrypted
+	cmd := rootCmd
+	cmd.SetArgs([]string{"reify", srcDir, "--secret=false"})
+	require.NoError(t, cmd.Execute())
+	raw, err := os.ReadFile(secretPath)
+	require.NoError(t, err)
+	assert.True(t, crypt.IsEncrypted(raw))
+	assert.NotContains(t, string(raw), "hunter2")
+
+	// Elsewhere, it is decrypted
+	cmd.SetArgs([]string{"reify", srcDir, "--secret=false", "--out", outDir})
+	require.NoError(t, cmd.Execute())
+	content, err := os.ReadFile(filepath.Join(outDir, ".netrc"))
+	require.NoError(t, err)
+	assert.Equal(t, "password hunter2", string(content))
+	require.NoError(t, reifyCmd.Flags().Set("out", ""))
+
+	// An encrypted template cannot stay encrypted and be rendered
+	encrypt(".gitconfig", "name = {{ .root.name }}")
+	cmd.SetArgs([]string{"reify", srcDir, "--secret=false"})
+	err = cmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "needs a recipient")
+	raw, err = os.ReadFile(filepath.Join(srcDir, ".gitconfig"))
+	require.NoError(t, err)
+	assert.True(t, crypt.IsEncrypted(raw))
+}
+
+func TestReifyCommand_Delims(t *testing.T) {
+	rootDir := setupTestDir(t)
+
+	files := map[string]string{
+		".scadufax/manifest.toml": `
+[[file]]
+path = ".config/helm/**"
+delims = "[[ ]]"
+`,
+		".config/helm/values.yaml": "image: {{ .Values.image }}\nname: [[ .root.name ]]\n",
+		"motd.j2":                  "# scadufax: delims=\"<< >>\"\n{% if admin %}{{ user }}{% endif %} << .root.name >>\n",
+		".gitconfig":               "name = {{ .root.name }}\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(rootDir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+
+	resetViper()
+	viper.Set("scadufax.cache_dir", setupTestDir(t))
+	viper.Set("root.name", "box")
+
+	cmd := rootCmd
+	cmd.SetArgs([]string{"reify", rootDir, "--secret=false"})
+	require.NoError(t, cmd.Execute())
+
+	content, _ := os.ReadFile(filepath.Join(rootDir, ".config", "helm", "values.yaml"))
+	assert.Equal(t, "image: {{ .Values.image }}\nname: box\n", string(content))
+
+	// The header line is not part of the output
+	content, _ = os.ReadFile(filepath.Join(rootDir, "motd.j2"))
+	assert.Equal(t, "{% if admin %}{{ user }}{% endif %} box\n", string(content))
+
+	content, _ = os.ReadFile(filepath.Join(rootDir, ".gitconfig"))
+	assert.Equal(t, "name = box\n", string(content))
+
+	t.Run("Invalid Delims", func(t *testing.T) {
+		manifestPath := filepath.Join(rootDir, ".scadufax", "manifest.toml")
+		err := os.WriteFile(manifestPath, []byte("[[file]]\npath = \"x\"\ndelims = \"[[\"\n"), 0644)
+		require.NoError(t, err)
+
+		cmd := rootCmd
+		cmd.SetArgs([]string{"reify", rootDir, "--secret=false"})
+		err = cmd.Execute()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "must be two delimiters")
+	})
+}
+
+func TestReifyCommand_Prompt(t *testing.T) {
+	rootDir := setupTestDir(t)
+	cfgDir := t.TempDir()
+
+	files := map[string]string{
+		"git.conf":   "email = {{ promptString \"root.email\" \"Your email\" }}\nname = {{ .root.name }}\n",
+		"shell.conf": "shell = {{ promptChoice \"root.shell\" \"Login shell\" (list \"bash\" \"zsh\") \"zsh\" }}\n{{ if promptBool \"root.work\" \"Work machine\" }}proxy = on\n{{ end }}",
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(rootDir, name), []byte(content), 0644))
+	}
+	localPath := filepath.Join(cfgDir, "local.toml")
+	require.NoError(t, os.WriteFile(localPath, []byte("# Mine\n[root]\nname = 'me' # not root\n\n[other]\nx = 1\n"), 0600))
+
+	resetViper()
+	viper.Set("scadufax.config_dir", cfgDir)
+	viper.Set("root.name", "me")
+
+	// Without the values, rendering fails
+	rootCmd.SetArgs([]string{"reify", rootDir, "--secret=false"})
+	err := rootCmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "root.email is not set")
+
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	oldStdin := os.Stdin
+	defer func() { os.Stdin = oldStdin }()
+	os.Stdin = r
+	// Email, then an empty answer for the default shell, then an invalid
+	// and a valid answer for the bool
+	_, err = w.Write([]byte("me@example.com\n\nmaybe\ny\n"))
+	require.NoError(t, err)
+	w.Close()
+
+	rootCmd.SetArgs([]string{"reify", rootDir, "--secret=false", "--prompt"})
+	defer reifyCmd.Flags().Set("prompt", "false")
+	out := captureOutput(func() {
+		require.NoError(t, rootCmd.Execute())
+	})
+	assert.Contains(t, out, "Your email: ")
+	assert.Contains(t, out, "[2] zsh")
+	assert.Contains(t, out, "Please answer y or n.")
+
+	content, err := os.ReadFile(filepath.Join(rootDir, "git.conf"))
+	require.NoError(t, err)
+	assert.Equal(t, "email = me@example.com\nname = me\n", string(content))
+	content, err = os.ReadFile(filepath.Join(rootDir, "shell.conf"))
+	require.NoError(t, err)
+	assert.Equal(t, "shell = zsh\nproxy = on\n", string(content))
+
+	// The answers are added to what local.toml held, which is left alone
+	content, err = os.ReadFile(localPath)
+	require.NoError(t, err)
+	assert.Equal(t, "# Mine\n[root]\nname = 'me' # not root\nemail = 'me@example.com'\nshell = 'zsh'\nwork = true\n\n[other]\nx = 1\n", string(content))
+	info, err := os.Stat(localPath)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestReifyCommand_Perms(t *testing.T) {
+	rootDir := setupTestDir(t)
+	require.NoError(t, os.MkdirAll(filepath.Join(rootDir, ".scadufax"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(rootDir, ".ssh"), 0755))
+
+	rules := "[[file]]\npath = \".ssh/**\"\nperm = \"0600\"\n\n[[file]]\npath = \"app.conf\"\nperm = \"0640\"\n\n[[dir]]\npath = \".gnupg\"\nperm = \"0700\"\n"
+	require.NoError(t, os.WriteFile(filepath.Join(rootDir, ".scadufax", "manifest.toml"), []byte(rules), 0644))
+	// A plain file, copied, and a template, rendered
+	require.NoError(t, os.WriteFile(filepath.Join(rootDir, ".ssh", "id_ed25519"), []byte("key"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(rootDir, "app.conf.tmpl"), []byte("name = {{ .root.name }}"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(rootDir, "other.conf"), []byte("other"), 0644))
+
+	resetViper()
+	viper.Set("root.name", "box")
+	viper.Set("scadufax.template_suffix", ".tmpl")
+
+	for range 2 {
+		rootCmd.SetArgs([]string{"reify", rootDir, "--secret=false"})
+		require.NoError(t, rootCmd.Execute())
+
+		modes := map[string]fs.FileMode{
+			filepath.Join(".ssh", "id_ed25519"): 0600,
+			"app.conf":                          0640,
+			"other.conf":                        0644,
+			".gnupg":                            0700,
+		}
+		for name, mode := range modes {
+			info, err := os.Stat(filepath.Join(rootDir, name))
+			require.NoError(t, err, name)
+			assert.Equal(t, mode, info.Mode().Perm(), name)
+		}
+	}
+	content, err := os.ReadFile(filepath.Join(rootDir, ".ssh", "id_ed25519"))
+	require.NoError(t, err)
+	assert.Equal(t, "key", string(content))
+}
+
+func TestReifyCommand_Symlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping symlink test on Windows")
+	}
+
+	tmpDir := setupTestDir(t)
+	outDir := filepath.Join(setupTestDir(t), "out")
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, ".bashrc"), []byte("# {{ .sys.os }}\n"), 0644))
+	require.NoError(t, os.Symlink(".bashrc", filepath.Join(tmpDir, ".profile")))
+	require.NoError(t, os.Symlink("/etc/inputrc", filepath.Join(tmpDir, ".inputrc##os.plan9")))
+
+	resetViper()
+	viper.Set("sys.os", "plan9")
+	defer reifyCmd.Flags().Set("out", "")
+
+	// Links are recreated, not replaced by what they point to
+	rootCmd.SetArgs([]string{"reify", tmpDir, "--secret=false", "--out", outDir})
+	require.NoError(t, rootCmd.Execute())
+	dest, err := os.Readlink(filepath.Join(outDir, ".profile"))
+	require.NoError(t, err)
+	assert.Equal(t, ".bashrc", dest)
+	dest, err = os.Readlink(filepath.Join(outDir, ".inputrc"))
+	require.NoError(t, err)
+	assert.Equal(t, "/etc/inputrc", dest)
+
+	// In place they are left alone, an alternate only taking its base name
+	require.NoError(t, reifyCmd.Flags().Set("out", ""))
+	rootCmd.SetArgs([]string{"reify", tmpDir, "--secret=false"})
+	require.NoError(t, rootCmd.Execute())
+	dest, err = os.Readlink(filepath.Join(tmpDir, ".profile"))
+	require.NoError(t, err)
+	assert.Equal(t, ".bashrc", dest)
+	dest, err = os.Readlink(filepath.Join(tmpDir, ".inputrc"))
+	require.NoError(t, err)
+	assert.Equal(t, "/etc/inputrc", dest)
+	content, err := os.ReadFile(filepath.Join(tmpDir, ".bashrc"))
+	require.NoError(t, err)
+	assert.Equal(t, "# plan9\n", string(content))
+}
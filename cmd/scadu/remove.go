@@ -31,6 +31,12 @@ var removeCmd = &cobra.Command{
 			homeDir, _ = os.UserHomeDir()
 		}
 
+		// Hooks may stop the command before anything changes
+		homePaths := absPaths(args)
+		if err := runHook("pre_remove", homePaths, ""); err != nil {
+			return err
+		}
+
 		// 2. Ensure Main Branch
 		// Remove command operates on main branch
 		if err := gitops.Checkout(localDir, "main"); err != nil {
@@ -38,6 +44,7 @@ var removeCmd = &cobra.Command{
 		}
 
 		// 3. Process Arguments
+		var commitID string
 		for _, arg := range args {
 			// Resolve Abs Path
 			absPath, err := filepath.Abs(arg)
@@ -57,7 +64,8 @@ var removeCmd = &cobra.Command{
 				return fmt.Errorf("failed to get relative path: %w", err)
 			}
 
-			repoPath := filepath.Join(localDir, rel)
+			repoRel := repoRelFor(localDir, rel)
+			repoPath := filepath.Join(localDir, repoRel)
 
 			// Check if exists in repo
 			if _, err := os.Stat(repoPath); os.IsNotExist(err) {
@@ -66,19 +74,19 @@ var removeCmd = &cobra.Command{
 			}
 
 			// 4. Repo Removal
-			fmt.Printf("Removing %s from repository...\n", rel)
+			fmt.Printf("Removing %s from repository...\n", repoRel)
 			// repoPath for Remove needs to be absolute?
 			// gitops.Remove opens repo. And does w.Remove(filePath).
 			// go-git w.Remove documentation says: "removes the given file from the worktree and the index".
 			// Argument is filepath. "must be relative to the worktree root".
 			// So we pass 'rel'.
-			if err := gitops.Remove(localDir, rel); err != nil {
-				return fmt.Errorf("failed to remove %s from repo: %w", rel, err)
+			if err := gitops.Remove(localDir, repoRel); err != nil {
+				return fmt.Errorf("failed to remove %s from repo: %w", repoRel, err)
 			}
 
-			msg := GenerateCommitMessage(fmt.Sprintf("Remove %s via scadu remove", rel))
-			if err := gitops.CommitFile(localDir, rel, msg); err != nil {
-				return fmt.Errorf("failed to commit removal of %s: %w", rel, err)
+			commitID, err = commitFile(localDir, repoRel, fmt.Sprintf("Remove %s via scadu remove", repoRel))
+			if err != nil {
+				return fmt.Errorf("failed to commit removal of %s: %w", repoRel, err)
 			}
 
 			// 5. Local Removal
@@ -118,6 +126,7 @@ var removeCmd = &cobra.Command{
 		}
 
 		fmt.Println("Done.")
+		postHook("post_remove", homePaths, commitID)
 		return nil
 	},
 }
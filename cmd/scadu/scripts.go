@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/suderio/scadufax/pkg/processor"
+	"github.com/suderio/scadufax/pkg/state"
+)
+
+// pendingScript is a script that has to run, rendered.
+type pendingScript struct {
+	processor.Script
+	content []byte
+}
+
+// pendingScripts renders the scripts of the repository at repoDir and
+// returns those st says have to run: run_once_ scripts that never ran, and
+// run_onchange_ scripts whose content changed since they last ran. Scripts
+// rendering to nothing but whitespace never run, so a template can keep one
+// off some machines.
+func pendingScripts(repoDir, homeDir string, st *state.State) ([]pendingScript, error) {
+	scripts, err := processor.FindScripts(filepath.Join(repoDir, processor.ScriptsDir), templateSuffix())
+	if err != nil || len(scripts) == 0 {
+		return nil, err
+	}
+
+	registry, err := newSecrets(filepath.Join(homeDir, ".env"), false)
+	if err != nil {
+		return nil, err
+	}
+	includes, err := loadIncludes(repoDir, resolvedFork())
+	if err != nil {
+		return nil, err
+	}
+	renderer, err := processor.NewRenderer(processor.RendererOptions{
+		Data:    []map[string]any{templateData()},
+		Secrets: registry.Lookup,
+		Options: []processor.Option{includes},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []pendingScript
+	for _, s := range scripts {
+		content, err := os.ReadFile(s.Path)
+		if err != nil {
+			return nil, err
+		}
+		if s.Template {
+			if content, err = renderer.Render(s.Name, content); err != nil {
+				return nil, fmt.Errorf("failed to render script %s: %w", s.Name, err)
+			}
+		}
+		if strings.TrimSpace(string(content)) == "" {
+			continue
+		}
+
+		last, ran := st.Scripts[s.Name]
+		if s.Kind == processor.ScriptOnce && ran {
+			continue
+		}
+		if s.Kind == processor.ScriptOnChange && last == state.Sum(content) {
+			continue
+		}
+		pending = append(pending, pendingScript{Script: s, content: content})
+	}
+	return pending, nil
+}
+
+// scriptStatuses returns a record with status R for each script in
+// pending, from branch, listed as check lists files.
+func scriptStatuses(pending []pendingScript, branch string) []fileStatus {
+	var statuses []fileStatus
+	for _, s := range pending {
+		statuses = append(statuses, fileStatus{
+			Path:   filepath.ToSlash(processor.ScriptsDir) + "/" + s.Name,
+			Status: "R",
+			Branch: branch,
+			Source: &fileContent{SHA256: state.Sum(s.content), Size: int64(len(s.content))},
+		})
+	}
+	return statuses
+}
+
+// runScripts runs the scripts in pending, in order, from homeDir, recording
+// in st each one that succeeds. It stops at the first failure, which runs
+// again next time.
+func runScripts(pending []pendingScript, homeDir string, st *state.State) error {
+	if len(pending) == 0 {
+		return nil
+	}
+
+	dir, err := os.MkdirTemp("", "scadu-scripts-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i, s := range pending {
+		// Rendered scripts may hold secrets: only the owner can read them
+		path := filepath.Join(dir, fmt.Sprint(i), filepath.Base(filepath.FromSlash(s.Name)))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, s.content, 0700); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(messages(), "Running %s...\n", s.Name)
+		cmd := exec.Command(path)
+		cmd.Dir = homeDir
+		cmd.Env = os.Environ()
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = messages()
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("script %s failed: %w", s.Name, err)
+		}
+
+		st.Scripts[s.Name] = state.Sum(s.content)
+		if err := st.Save(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/suderio/scadufax/pkg/gitops"
+	"github.com/suderio/scadufax/pkg/manifest"
+	"github.com/suderio/scadufax/pkg/processor"
+	"github.com/suderio/scadufax/pkg/vault"
+)
+
+var secretCmd = &cobra.Command{
+	Use:   "secret",
+	Short: "Manage secrets in the encrypted vault",
+	Long: `Manages the encrypted secret vault backing the "vault" secret backend.
+
+The vault lives at ~/.config/scadufax/vault.enc (or secrets.vault in the
+configuration) and is encrypted with a key derived from a passphrase, read from
+$SCADUFAX_PASSPHRASE or asked for on the terminal.`,
+}
+
+var secretSetCmd = &cobra.Command{
+	Use:   "set KEY [VALUE]",
+	Short: "Store a secret (reads VALUE from stdin if omitted)",
+	Args:  cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		v, err := openVault()
+		if err != nil {
+			return err
+		}
+
+		var value string
+		if len(args) == 2 {
+			value = args[1]
+		} else {
+			fmt.Fprintf(os.Stderr, "Value for %s: ", args[0])
+			value, err = readLine(os.Stdin)
+			if err != nil {
+				return fmt.Errorf("failed to read value: %w", err)
+			}
+		}
+
+		v.Set(args[0], value)
+		return v.Save()
+	},
+}
+
+var secretGetCmd = &cobra.Command{
+	Use:   "get KEY",
+	Short: "Print a secret",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		v, err := openVault()
+		if err != nil {
+			return err
+		}
+		value, ok := v.Get(args[0])
+		if !ok {
+			return fmt.Errorf("secret %s not found in vault", args[0])
+		}
+		fmt.Println(value)
+		return nil
+	},
+}
+
+var secretListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the keys stored in the vault",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		v, err := openVault()
+		if err != nil {
+			return err
+		}
+		for _, key := range v.Keys() {
+			fmt.Println(key)
+		}
+		return nil
+	},
+}
+
+var secretRmCmd = &cobra.Command{
+	Use:   "rm KEY...",
+	Short: "Remove secrets from the vault",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		v, err := openVault()
+		if err != nil {
+			return err
+		}
+		for _, key := range args {
+			if !v.Delete(key) {
+				return fmt.Errorf("secret %s not found in vault", key)
+			}
+		}
+		return v.Save()
+	},
+}
+
+var secretEditCmd = &cobra.Command{
+	Use:   "edit",
+	Short: "Edit all secrets in the system editor, as a .env file",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		v, err := openVault()
+		if err != nil {
+			return err
+		}
+
+		// Decrypted copy only readable by the user, removed on exit
+		tmp, err := os.CreateTemp("", "scadu-secrets-*.env")
+		if err != nil {
+			return fmt.Errorf("failed to create temp file: %w", err)
+		}
+		defer os.Remove(tmp.Name())
+		if err := tmp.Chmod(0600); err != nil {
+			tmp.Close()
+			return err
+		}
+		content, err := godotenv.Marshal(v.Entries())
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		if _, err := tmp.WriteString(content + "\n"); err != nil {
+			tmp.Close()
+			return err
+		}
+		tmp.Close()
+
+		editor, err := resolveEditor()
+		if err != nil {
+			return err
+		}
+		editCmd := exec.Command(editor, tmp.Name())
+		editCmd.Stdin = os.Stdin
+		editCmd.Stdout = os.Stdout
+		editCmd.Stderr = os.Stderr
+		if err := editCmd.Run(); err != nil {
+			return fmt.Errorf("editor exited with error: %w", err)
+		}
+
+		entries, err := godotenv.Read(tmp.Name())
+		if err != nil {
+			return fmt.Errorf("failed to parse edited secrets: %w", err)
+		}
+		v.Replace(entries)
+		return v.Save()
+	},
+}
+
+var secretImportEnvCmd = &cobra.Command{
+	Use:   "import-env [FILE]",
+	Short: "Import the secrets of a .env file (default: the one in home) into the vault",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		envPath := ""
+		if len(args) == 1 {
+			envPath = args[0]
+		} else {
+			homeDir := viper.GetString("scadufax.home_dir")
+			if homeDir == "" {
+				homeDir, _ = os.UserHomeDir()
+			}
+			envPath = filepath.Join(homeDir, ".env")
+		}
+
+		entries, err := godotenv.Read(envPath)
+		if err != nil {
+			return fmt.Errorf("failed to read .env at %s: %w", envPath, err)
+		}
+
+		v, err := openVault()
+		if err != nil {
+			return err
+		}
+		for key, value := range entries {
+			v.Set(key, value)
+		}
+		if err := v.Save(); err != nil {
+			return err
+		}
+
+		fmt.Printf("Imported %d secrets from %s.\n", len(entries), envPath)
+		return nil
+	},
+}
+
+var secretUsagesCmd = &cobra.Command{
+	Use:   "usages KEY",
+	Short: "List every template in the main branch referencing a secret",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		localDir := viper.GetString("scadufax.local_dir")
+		if localDir == "" {
+			home, _ := os.UserHomeDir()
+			localDir = filepath.Join(home, ".local", "share", "scadufax")
+		}
+
+		if err := gitops.Checkout(localDir, "main"); err != nil {
+			return fmt.Errorf("failed to checkout main: %w", err)
+		}
+		rules, err := manifest.Load(filepath.Join(localDir, processor.MetaDir, manifest.FileName))
+		if err != nil {
+			return err
+		}
+
+		suffix := templateSuffix()
+		var usages []string
+		err = filepath.WalkDir(localDir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if d.Name() == ".git" {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
+			rel, err := filepath.Rel(localDir, path)
+			if err != nil {
+				return err
+			}
+			// Shared templates are always templates, whatever their name
+			if !isMetaPath(rel) && !processor.IsTemplate(rel, suffix) {
+				return nil
+			}
+			if binary, err := processor.IsBinary(path); err != nil || binary {
+				return err
+			}
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			// Every variant of a file shares the delimiters of its target;
+			// shared templates only have those of their header
+			var delims processor.Option = processor.WithDelims("", "")
+			if !isMetaPath(rel) {
+				target, _, _ := processor.ParseAlternate(filepath.ToSlash(processor.TargetName(rel, suffix)))
+				delims = processor.WithDelims(rules.Delims(target))
+			}
+			refs, err := processor.FindSecretRefs(rel, content, delims)
+			if err != nil {
+				// Unparsable files cannot reference secrets; lint reports them
+				return nil
+			}
+			for _, ref := range refs {
+				if ref.Key != args[0] {
+					continue
+				}
+				line := ref.Pos
+				if ref.Backend != "" {
+					line += " (" + ref.Backend + ")"
+				}
+				usages = append(usages, line)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to scan templates: %w", err)
+		}
+
+		sort.Strings(usages)
+		for _, usage := range usages {
+			fmt.Println(usage)
+		}
+		if len(usages) == 0 {
+			fmt.Printf("No templates reference %s.\n", args[0])
+		}
+		return nil
+	},
+}
+
+// vaultPath returns the location of the encrypted secret vault.
+func vaultPath() string {
+	if path := viper.GetString("secrets.vault"); path != "" {
+		return path
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".config", "scadufax", "vault.enc")
+}
+
+func openVault() (*vault.Vault, error) {
+	passphrase, err := readPassphrase()
+	if err != nil {
+		return nil, err
+	}
+	return vault.Open(vaultPath(), passphrase)
+}
+
+// readPassphrase returns $SCADUFAX_PASSPHRASE, or asks for the vault
+// passphrase on the terminal without echoing it.
+func readPassphrase() ([]byte, error) {
+	if pass, ok := os.LookupEnv("SCADUFAX_PASSPHRASE"); ok {
+		return []byte(pass), nil
+	}
+
+	fmt.Fprint(os.Stderr, "Vault passphrase: ")
+	if err := stty("-echo"); err == nil {
+		defer func() {
+			stty("echo")
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+	pass, err := readLine(os.Stdin)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	if pass == "" {
+		return nil, fmt.Errorf("empty vault passphrase")
+	}
+	return []byte(pass), nil
+}
+
+func stty(arg string) error {
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
+
+// readLine reads a single line from r without buffering past it, so later
+// prompts reading the same input still see the rest.
+func readLine(r io.Reader) (string, error) {
+	var line strings.Builder
+	buf := make([]byte, 1)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if buf[0] == '\n' {
+				break
+			}
+			line.WriteByte(buf[0])
+		}
+		if err == io.EOF {
+			if line.Len() == 0 {
+				return "", err
+			}
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimSuffix(line.String(), "\r"), nil
+}
+
+func init() {
+	secretCmd.AddCommand(secretSetCmd, secretGetCmd, secretListCmd, secretRmCmd,
+		secretEditCmd, secretImportEnvCmd, secretUsagesCmd)
+	rootCmd.AddCommand(secretCmd)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretCommand_Integration(t *testing.T) {
+	rootDir := setupTestDir(t)
+	homeDir := filepath.Join(rootDir, "home")
+	localDir := filepath.Join(rootDir, "local")
+	vaultFile := filepath.Join(rootDir, "config", "vault.enc")
+
+	err := os.MkdirAll(homeDir, 0755)
+	require.NoError(t, err)
+
+	viper.Reset()
+	viper.Set("scadufax.local_dir", localDir)
+	viper.Set("scadufax.home_dir", homeDir)
+	viper.Set("secrets.vault", vaultFile)
+	t.Setenv("SCADUFAX_PASSPHRASE", "correct horse")
+
+	run := func(args ...string) (string, error) {
+		var err error
+		output := captureOutput(func() {
+			cmd := rootCmd
+			cmd.SetArgs(args)
+			err = cmd.Execute()
+		})
+		return output, err
+	}
+
+	t.Run("Set Get List", func(t *testing.T) {
+		_, err := run("secret", "set", "API_KEY", "s3cr3t")
+		require.NoError(t, err)
+		_, err = run("secret", "set", "OTHER", "value")
+		require.NoError(t, err)
+
+		info, err := os.Stat(vaultFile)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+		// Not stored in clear text
+		raw, _ := os.ReadFile(vaultFile)
+		assert.NotContains(t, string(raw), "s3cr3t")
+
+		output, err := run("secret", "get", "API_KEY")
+		require.NoError(t, err)
+		assert.Equal(t, "s3cr3t\n", output)
+
+		output, err = run("secret", "list")
+		require.NoError(t, err)
+		assert.Equal(t, "API_KEY\nOTHER\n", output)
+	})
+
+	t.Run("Wrong Passphrase", func(t *testing.T) {
+		t.Setenv("SCADUFAX_PASSPHRASE", "battery staple")
+		_, err := run("secret", "get", "API_KEY")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "wrong passphrase")
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		_, err := run("secret", "rm", "OTHER")
+		require.NoError(t, err)
+
+		_, err = run("secret", "get", "OTHER")
+		require.Error(t, err)
+	})
+
+	t.Run("Import Env", func(t *testing.T) {
+		err := os.WriteFile(filepath.Join(homeDir, ".env"), []byte("DB_PASS=hunter2\n"), 0600)
+		require.NoError(t, err)
+
+		_, err = run("secret", "import-env")
+		require.NoError(t, err)
+
+		output, err := run("secret", "get", "DB_PASS")
+		require.NoError(t, err)
+		assert.Equal(t, "hunter2\n", output)
+	})
+
+	t.Run("Vault Backs Templates", func(t *testing.T) {
+		target := filepath.Join(rootDir, "target")
+		err := os.MkdirAll(target, 0755)
+		require.NoError(t, err)
+		err = os.WriteFile(filepath.Join(target, ".env"), []byte("API_KEY=from-dotenv\n"), 0600)
+		require.NoError(t, err)
+		tmpl := `{{ "API_KEY" | secret }} {{ secret "DB_PASS" "vault" }}`
+		tmplPath := filepath.Join(target, "file.txt")
+		err = os.WriteFile(tmplPath, []byte(tmpl), 0644)
+		require.NoError(t, err)
+
+		// The vault existing does not take over from dotenv
+		_, err = run("reify", target, "--secret=true")
+		require.NoError(t, err)
+		content, _ := os.ReadFile(tmplPath)
+		assert.Equal(t, "from-dotenv hunter2", string(content))
+
+		// Unless it is made the default
+		viper.Set("secrets.default", "vault")
+		defer viper.Set("secrets.default", "")
+		err = os.WriteFile(tmplPath, []byte(tmpl), 0644)
+		require.NoError(t, err)
+		_, err = run("reify", target, "--secret=true")
+		require.NoError(t, err)
+		content, _ = os.ReadFile(tmplPath)
+		assert.Equal(t, "s3cr3t hunter2", string(content))
+	})
+
+	t.Run("Invalid Cost Parameters", func(t *testing.T) {
+		raw, err := os.ReadFile(vaultFile)
+		require.NoError(t, err)
+		defer os.WriteFile(vaultFile, raw, 0600)
+
+		var f map[string]any
+		require.NoError(t, json.Unmarshal(raw, &f))
+		f["n"] = 1 << 40
+		bad, err := json.Marshal(f)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(vaultFile, bad, 0600))
+
+		_, err = run("secret", "get", "API_KEY")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "scrypt N")
+	})
+
+	t.Run("Usages", func(t *testing.T) {
+		repo, err := git.PlainInit(localDir, false)
+		require.NoError(t, err)
+		err = repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, "refs/heads/main"))
+		require.NoError(t, err)
+
+		err = os.MkdirAll(filepath.Join(localDir, ".config", "app"), 0755)
+		require.NoError(t, err)
+		err = os.WriteFile(filepath.Join(localDir, ".netrc"), []byte("password {{ \"API_KEY\" | secret }}\n"), 0644)
+		require.NoError(t, err)
+		err = os.WriteFile(filepath.Join(localDir, ".config", "app", "conf"), []byte("a\n{{ if true }}\n  k = {{ secret \"API_KEY\" \"pass\" }}\n{{ end }}\n"), 0644)
+		require.NoError(t, err)
+		err = os.WriteFile(filepath.Join(localDir, "other"), []byte(`{{ "DB_PASS" | secret }}`), 0644)
+		require.NoError(t, err)
+
+		w, _ := repo.Worktree()
+		w.Add(".")
+		_, err = w.Commit("Init", &git.CommitOptions{Author: &object.Signature{Name: "T", Email: "t", When: time.Now()}})
+		require.NoError(t, err)
+
+		output, err := run("secret", "usages", "API_KEY")
+		require.NoError(t, err)
+		assert.Contains(t, output, ".config/app/conf:3:")
+		assert.Contains(t, output, "(pass)")
+		assert.Contains(t, output, ".netrc:1:")
+		assert.NotContains(t, output, "other")
+	})
+
+	t.Run("Usages With Custom Delimiters", func(t *testing.T) {
+		repo, err := git.PlainOpen(localDir)
+		require.NoError(t, err)
+
+		files := map[string]string{
+			".scadufax/manifest.toml": "[[file]]\npath = \".config/helm/**\"\ndelims = \"[[ ]]\"\n",
+			// Braces are plain text here
+			".config/helm/values.yaml##os.linux": "image: {{ .Values.image }}\ntoken: [[ secret \"API_KEY\" ]]\n",
+			".tmux.conf":                         "# scadufax: delims=\"<< >>\"\nset -g status-left '{{ x }}'\nset -g @token '<< \"API_KEY\" | secret >>'\n",
+		}
+		for name, content := range files {
+			path := filepath.Join(localDir, name)
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+			require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		}
+
+		w, _ := repo.Worktree()
+		w.Add(".")
+		_, err = w.Commit("Delims", &git.CommitOptions{Author: &object.Signature{Name: "T", Email: "t", When: time.Now()}})
+		require.NoError(t, err)
+
+		output, err := run("secret", "usages", "API_KEY")
+		require.NoError(t, err)
+		assert.Contains(t, output, ".config/helm/values.yaml##os.linux:2:")
+		// Lines are counted from the header
+		assert.Contains(t, output, ".tmux.conf:3:")
+		assert.Contains(t, output, ".netrc:1:")
+	})
+}
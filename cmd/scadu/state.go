@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/viper"
+	"github.com/suderio/scadufax/pkg/state"
+)
+
+// stateDir returns where scadufax keeps what it did on this machine:
+// scadufax.state_dir, or scadufax under $XDG_STATE_HOME.
+func stateDir() string {
+	if dir := viper.GetString("scadufax.state_dir"); dir != "" {
+		return dir
+	}
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "scadufax")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".local", "state", "scadufax")
+}
+
+// loadState loads the state of this machine.
+func loadState() (*state.State, error) {
+	return state.Load(filepath.Join(stateDir(), state.FileName))
+}
+
+// installedSum returns the Sum of what the file at path holds once
+// installed: where it points when asLink, the plain text of an encrypted
+// file, or its content, following links.
+func installedSum(path string, asLink bool) (string, error) {
+	if asLink {
+		dest, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		return state.Sum([]byte(dest)), nil
+	}
+	protected, err := isProtected(path)
+	if err != nil {
+		return "", err
+	}
+	if protected {
+		content, _, err := readDecrypted(path)
+		if err != nil {
+			return "", err
+		}
+		return state.Sum(content), nil
+	}
+	return state.SumFile(path)
+}
+
+// driftStatus tells which side moved since the file installed as target was
+// installed, given that the repository file src and the home file dst now
+// differ: "M" when home changed, "U" when the repository did and an update
+// is pending, "C" when both did. Without a record of the install in st,
+// e.g. one made before records were kept, neither side can be told and the
+// difference is reported as "O". A nil st reports every difference as "M".
+func driftStatus(st *state.State, target, src, dst string) string {
+	if st == nil {
+		return "M"
+	}
+	installed, ok := st.Files[target]
+	if !ok {
+		return "O"
+	}
+	asLink := isSymlink(src)
+	homeSum, err := installedSum(dst, asLink)
+	if err != nil {
+		return "M"
+	}
+	repoSum, err := installedSum(src, asLink)
+	if err != nil {
+		return "M"
+	}
+	homeMoved, repoMoved := homeSum != installed.Sum, repoSum != installed.Sum
+	switch {
+	case homeMoved && repoMoved:
+		return "C"
+	case repoMoved:
+		return "U"
+	}
+	return "M"
+}
+
+// recordInstall records in st that the repository file src was installed
+// as target, at dst, from the commit with the SCADUFAX_ID id.
+func recordInstall(st *state.State, target, src, dst, id string) error {
+	sum, err := installedSum(dst, isSymlink(src))
+	if err != nil {
+		return err
+	}
+	f := state.File{Sum: sum, ID: id}
+	if info, err := os.Stat(dst); err == nil {
+		f.Mode = info.Mode().Perm()
+	}
+	st.Files[target] = f
+	return nil
+}
@@ -1,9 +1,7 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
-	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
@@ -14,11 +12,21 @@ import (
 	"github.com/suderio/scadufax/pkg/gitops"
 )
 
-var updateWait bool
+var (
+	updateWait   bool
+	updateDryRun bool
+)
 
 var updateCmd = &cobra.Command{
 	Use:   "update",
 	Short: "Update home directory from fork branch",
+	Long: `Pushes main, pulls the fork branch and lists how home differs from it, as
+check does, then asks to install the changes and runs the scripts that are due.
+
+Once confirmed, new files and updates from the repository (N, U, P, L) are
+installed, and so are files with no record of their install (O). A file changed in home (M), or in both home and the repository
+(C), is only overwritten if you also confirm it on its own; otherwise home
+keeps it as it is.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		// 1. Resolve Config
 		localDir := viper.GetString("scadufax.local_dir")
@@ -30,44 +38,49 @@ var updateCmd = &cobra.Command{
 		if homeDir == "" {
 			homeDir, _ = os.UserHomeDir()
 		}
-		forkName := viper.GetString("scadufax.fork")
-		if forkName == "" {
-			hostname, _ := os.Hostname()
-			forkName = hostname
-		}
+		forkName := resolvedFork()
 		ignorePatterns := viper.GetStringSlice("root.ignore")
 
 		// 2. Push Main
-		fmt.Println("Switching to main...")
+		fmt.Fprintln(messages(), "Switching to main...")
 		if err := gitops.Checkout(localDir, "main"); err != nil {
 			return fmt.Errorf("failed to checkout main: %w", err)
 		}
 
-		fmt.Println("Pushing main to origin...")
-		if err := gitops.Push(localDir); err != nil {
-			return fmt.Errorf("failed to push main: %w", err)
-		}
-
 		mainID, err := gitops.GetHeadID(localDir)
 		if err != nil {
 			return fmt.Errorf("failed to get main ID: %w", err)
 		}
-		fmt.Printf("Main SCADUFAX_ID: %s\n", mainID)
+
+		if updateDryRun {
+			fmt.Fprintln(messages(), "Dry run: not pushing main.")
+		} else {
+			// The hook may stop the update before anything leaves this
+			// machine; what will change is not known until the fork is pulled
+			if err := runHook("pre_update", nil, mainID); err != nil {
+				return err
+			}
+			fmt.Fprintln(messages(), "Pushing main to origin...")
+			if err := gitops.Push(localDir); err != nil {
+				return fmt.Errorf("failed to push main: %w", err)
+			}
+		}
+		fmt.Fprintf(messages(), "Main SCADUFAX_ID: %s\n", mainID)
 
 		// 3. Pull Fork and Wait
-		fmt.Printf("Switching to fork '%s'...\n", forkName)
+		fmt.Fprintf(messages(), "Switching to fork '%s'...\n", forkName)
 		if err := gitops.Checkout(localDir, forkName); err != nil {
 			return fmt.Errorf("failed to checkout fork: %w", err)
 		}
 
-		fmt.Println("Pulling fork...")
+		fmt.Fprintln(messages(), "Pulling fork...")
 		if err := gitops.Pull(localDir); err != nil {
 			// Pull fail might be ok if remote branch doesn't exist yet/matches local
-			fmt.Printf("Pull warning: %v\n", err)
+			fmt.Fprintf(messages(), "Pull warning: %v\n", err)
 		}
 
 		if updateWait && mainID != "" {
-			fmt.Println("Waiting for fork to sync with main ID...")
+			fmt.Fprintln(messages(), "Waiting for fork to sync with main ID...")
 			for {
 				forkID, err := gitops.GetHeadID(localDir)
 				if err != nil {
@@ -75,123 +88,164 @@ var updateCmd = &cobra.Command{
 				}
 
 				if forkID == mainID {
-					fmt.Println("Fork synced with Main ID.")
+					fmt.Fprintln(messages(), "Fork synced with Main ID.")
 					break
 				}
 
-				fmt.Printf("Fork ID (%s) != Main ID (%s). Retrying in 5s...\n", forkID, mainID)
+				fmt.Fprintf(messages(), "Fork ID (%s) != Main ID (%s). Retrying in 5s...\n", forkID, mainID)
 				time.Sleep(5 * time.Second)
 
-				fmt.Println("Pulling fork...")
+				fmt.Fprintln(messages(), "Pulling fork...")
 				if err := gitops.Pull(localDir); err != nil {
-					fmt.Printf("Pull warning: %v\n", err)
+					fmt.Fprintf(messages(), "Pull warning: %v\n", err)
 				}
 			}
 		}
 
-		// 4. Check Differences (Fork vs Home)
-		// We reuse compareDirs logic but need to capture changed files.
-		// Since compareDirs in check.go prints, let's just use it to show diffs?
-		// Requirement: "shows differences in the same way of the check command"
-		// Requirement: "ask if user wants to update ... copies changed/new files"
-		// We need to know IF there are differences and WHICH files.
-		// Refactoring check.go to return list of diffs is best.
-		// For now, let's implement a local walker here that does both: print and collect.
-
-		diffs, err := getDiffs(localDir, homeDir, ignorePatterns)
+		// 4. Check Differences (Fork vs Home), shown as check shows them
+		// Files the manifest excludes for this machine are never installed
+		filter, err := fileFilter(localDir)
 		if err != nil {
 			return err
 		}
 
-		if len(diffs) == 0 {
-			fmt.Println("No differences found. Home is up to date.")
-			return nil
-		}
-
-		// confirm update
-		fmt.Print("Update home directory with these changes? [y/N]: ")
-		reader := bufio.NewReader(os.Stdin)
-		resp, _ := reader.ReadString('\n')
-		resp = strings.TrimSpace(strings.ToLower(resp))
-		if resp != "y" && resp != "yes" {
-			fmt.Println("Update aborted.")
-			return nil
+		rules, err := loadManifest(localDir)
+		if err != nil {
+			return err
 		}
 
-		// Copy files
-		for _, rel := range diffs {
-			src := filepath.Join(localDir, rel)
-			dst := filepath.Join(homeDir, rel)
-			fmt.Printf("Updating %s...\n", rel)
-			if err := copyFile(src, dst); err != nil {
-				return fmt.Errorf("failed to update %s: %w", rel, err)
-			}
+		// What was installed tells which side moved since, and which
+		// scripts already ran
+		st, err := loadState()
+		if err != nil {
+			return err
 		}
 
-		fmt.Println("Update complete.")
-		return nil
-	},
-}
-
-func init() {
-	updateCmd.Flags().BoolVar(&updateWait, "wait", false, "wait for fork branch to catch up with main")
-	rootCmd.AddCommand(updateCmd)
-}
-
-// getDiffs prints diffs (like check) and returns list of modified/new files in source (Repo).
-func getDiffs(sourceDir, targetDir string, ignores []string) ([]string, error) {
-	var changes []string
-
-	// Reuse check logic colors? We can import color or just plain text if needed.
-	// We want to match check command output.
-	// Copy pasted/adapted from check.go (or we should export functions from check.go)
-	// Since check.go is package main, we can create a shared compare.go in cmd/scadu if we want.
-	// But simple logic reproduction here is fine for now to avoid large refactor risks.
-	// NOTE: check.go uses green/yellow/red from fatih/color.
-
-	err := filepath.WalkDir(sourceDir, func(path string, d fs.DirEntry, err error) error {
+		statuses, err := compareDirs(localDir, homeDir, forkName, ignorePatterns, false, filter, rules, st)
 		if err != nil {
 			return err
 		}
-		if d.IsDir() {
-			if d.Name() == ".git" {
-				return filepath.SkipDir
+		var files, dirs []fileStatus
+		for _, s := range statuses {
+			if s.Dir {
+				dirs = append(dirs, s)
+			} else {
+				files = append(files, s)
 			}
-			return nil
 		}
 
-		rel, err := filepath.Rel(sourceDir, path)
+		// Scripts run once, or whenever their content changes
+		scripts, err := pendingScripts(localDir, homeDir, st)
 		if err != nil {
 			return err
 		}
 
-		if isIgnored(rel, ignores) {
+		statuses = append(statuses, scriptStatuses(scripts, forkName)...)
+		if output == outputText {
+			printStatuses(statuses)
+		} else if err := writeRecords(statuses); err != nil {
+			return err
+		}
+
+		if len(statuses) == 0 {
+			fmt.Fprintln(messages(), "No differences found. Home is up to date.")
+			return nil
+		}
+		if updateDryRun {
+			fmt.Fprintln(messages(), "Dry run: home left untouched.")
 			return nil
 		}
 
-		targetPath := filepath.Join(targetDir, rel)
+		// confirm update
+		if !confirmed("Update home directory with these changes?") {
+			fmt.Fprintln(messages(), "Update aborted.")
+			return nil
+		}
 
-		isNew := false
-		isMod := false
+		// Changes made in home are only overwritten file by file
+		installs := files[:0]
+		for _, f := range files {
+			switch f.Status {
+			case "M":
+				if !confirmed(fmt.Sprintf("%s was changed in home. Overwrite it?", f.Path)) {
+					fmt.Fprintf(messages(), "Keeping %s.\n", f.Path)
+					continue
+				}
+			case "C":
+				if !confirmed(fmt.Sprintf("%s was changed in home and in the repository. Overwrite it?", f.Path)) {
+					fmt.Fprintf(messages(), "Keeping %s.\n", f.Path)
+					continue
+				}
+			}
+			installs = append(installs, f)
+		}
+		files = installs
 
-		if _, err := os.Stat(targetPath); os.IsNotExist(err) {
-			isNew = true
-		} else {
-			if areFilesDifferent(path, targetPath) {
-				isMod = true
+		var paths []string
+		for _, s := range append(dirs, files...) {
+			paths = append(paths, filepath.Join(homeDir, filepath.FromSlash(s.Path)))
+		}
+
+		// Directories first, so files are created in them with their modes
+		for _, d := range dirs {
+			fmt.Fprintf(messages(), "Updating %s...\n", d.Path)
+			if err := installDir(homeDir, d.dir); err != nil {
+				return err
+			}
+		}
+
+		// Copy files, recording the commit of the fork they come from
+		forkID, _ := gitops.GetHeadID(localDir)
+		for _, f := range files {
+			rel, target := f.rel, f.Path
+			src := filepath.Join(localDir, rel)
+			dst := filepath.Join(homeDir, filepath.FromSlash(target))
+			fmt.Fprintf(messages(), "Updating %s...\n", rel)
+			if rules.Symlink(target) {
+				if err := installLink(rules, src, target, dst); err != nil {
+					return fmt.Errorf("failed to update %s: %w", rel, err)
+				}
+			} else {
+				if err := installFile(src, dst); err != nil {
+					return fmt.Errorf("failed to update %s: %w", rel, err)
+				}
+				if err := applyPerm(rules, target, dst); err != nil {
+					return err
+				}
+			}
+			if err := recordInstall(st, target, src, dst, forkID); err != nil {
+				return err
 			}
 		}
+		if err := st.Save(); err != nil {
+			return err
+		}
 
-		if isNew {
-			fmt.Printf("N\t%s\n", rel) // Green N ideally
-			changes = append(changes, rel)
-		} else if isMod {
-			fmt.Printf("M\t%s\n", rel) // Yellow M ideally
-			changes = append(changes, rel)
+		if len(paths) > 0 {
+			postHook("post_install", paths, mainID)
 		}
 
+		if err := runScripts(scripts, homeDir, st); err != nil {
+			return err
+		}
+
+		fmt.Fprintln(messages(), "Update complete.")
+		postHook("post_update", paths, mainID)
 		return nil
-	})
+	},
+}
+
+// confirmed asks question and reports whether the answer is yes. Input is
+// read a line at a time, so that several questions can be answered.
+func confirmed(question string) bool {
+	fmt.Fprintf(messages(), "%s [y/N]: ", question)
+	resp, _ := readLine(os.Stdin)
+	resp = strings.TrimSpace(strings.ToLower(resp))
+	return resp == "y" || resp == "yes"
+}
 
-	return changes, err
+func init() {
+	updateCmd.Flags().BoolVar(&updateWait, "wait", false, "wait for fork branch to catch up with main")
+	updateCmd.Flags().BoolVar(&updateDryRun, "dry-run", false, "list the changes and scripts to run without applying them")
+	rootCmd.AddCommand(updateCmd)
 }
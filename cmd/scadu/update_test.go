@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"testing"
@@ -14,6 +15,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/suderio/scadufax/pkg/gitops"
+	"github.com/suderio/scadufax/pkg/state"
 )
 
 func TestUpdateCommand_Integration(t *testing.T) {
@@ -72,6 +74,7 @@ func TestUpdateCommand_Integration(t *testing.T) {
 	viper.Set("scadufax.local_dir", localPath)
 	viper.Set("scadufax.home_dir", homePath)
 	viper.Set("scadufax.fork", "fork")
+	viper.Set("scadufax.state_dir", filepath.Join(rootDir, "state"))
 
 	t.Run("Update_No_Changes", func(t *testing.T) {
 		// Home has "v1" (synced)
@@ -160,4 +163,271 @@ func TestUpdateCommand_Integration(t *testing.T) {
 		content, _ := os.ReadFile(filepath.Join(homePath, "file.txt"))
 		assert.Equal(t, "v2", string(content))
 	})
+
+	t.Run("Update_Symlink_Mode", func(t *testing.T) {
+		reified := filepath.Join(rootDir, "reified")
+		viper.Set("scadufax.reified_dir", reified)
+
+		gitops.Checkout(localPath, "fork")
+		os.MkdirAll(filepath.Join(localPath, ".scadufax"), 0755)
+		os.WriteFile(filepath.Join(localPath, ".scadufax", "manifest.toml"), []byte("[[file]]\npath = \"app.json\"\nmode = \"symlink\"\n"), 0644)
+		os.WriteFile(filepath.Join(localPath, "app.json"), []byte("{}\n"), 0644)
+		w.Add(".")
+		w.Commit("Linked file", &git.CommitOptions{Author: &object.Signature{Name: "T", Email: "t", When: time.Now()}})
+
+		r, wPipe, _ := os.Pipe()
+		oldStdin := os.Stdin
+		defer func() { os.Stdin = oldStdin }()
+		os.Stdin = r
+		wPipe.Write([]byte("y\n"))
+		wPipe.Close()
+
+		rootCmd.SetArgs([]string{"update"})
+		require.NoError(t, rootCmd.Execute())
+
+		// Home links to the reified copy
+		homeFile := filepath.Join(homePath, "app.json")
+		dest, err := os.Readlink(homeFile)
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(reified, "app.json"), dest)
+		content, err := os.ReadFile(homeFile)
+		require.NoError(t, err)
+		assert.Equal(t, "{}\n", string(content))
+
+		output := captureOutput(func() {
+			rootCmd.SetArgs([]string{"check", "--local", "--full=false"})
+			assert.NoError(t, rootCmd.Execute())
+		})
+		assert.NotContains(t, output, "app.json")
+
+		// A tool replacing the link with a copy is drift, even with the same content
+		require.NoError(t, os.Remove(homeFile))
+		require.NoError(t, os.WriteFile(homeFile, []byte("{}\n"), 0644))
+		output = captureOutput(func() {
+			rootCmd.SetArgs([]string{"check", "--local", "--full=false"})
+			assert.NoError(t, rootCmd.Execute())
+		})
+		assert.Contains(t, output, "L\tapp.json")
+
+		// So is a broken link
+		require.NoError(t, os.Remove(filepath.Join(reified, "app.json")))
+		require.NoError(t, os.Remove(homeFile))
+		require.NoError(t, os.Symlink(filepath.Join(reified, "app.json"), homeFile))
+		output = captureOutput(func() {
+			rootCmd.SetArgs([]string{"check", "--local", "--full=false"})
+			assert.NoError(t, rootCmd.Execute())
+		})
+		assert.Contains(t, output, "L\tapp.json")
+	})
+
+	t.Run("Update_Scripts", func(t *testing.T) {
+		viper.Set("root.fonts", "v1")
+
+		gitops.Checkout(localPath, "fork")
+		scripts := filepath.Join(localPath, ".scadufax", "scripts")
+		os.MkdirAll(scripts, 0755)
+		os.WriteFile(filepath.Join(scripts, "run_once_plugins.sh"), []byte("#!/bin/sh\necho once >> once.log\n"), 0755)
+		os.WriteFile(filepath.Join(scripts, "run_onchange_fonts.sh"), []byte("#!/bin/sh\necho {{ .root.fonts }} >> fonts.log\n"), 0755)
+		os.WriteFile(filepath.Join(scripts, "helpers.sh"), []byte("exit 1\n"), 0755)
+		os.WriteFile(filepath.Join(scripts, "run_once_never.sh"), []byte("{{ if eq .sys.os \"plan9\" }}#!/bin/sh\nexit 1{{ end }}\n"), 0755)
+		w.Add(".")
+		w.Commit("Scripts", &git.CommitOptions{Author: &object.Signature{Name: "T", Email: "t", When: time.Now()}})
+
+		update := func(args ...string) string {
+			r, wPipe, _ := os.Pipe()
+			oldStdin := os.Stdin
+			defer func() { os.Stdin = oldStdin }()
+			os.Stdin = r
+			wPipe.Write([]byte("y\n"))
+			wPipe.Close()
+
+			return captureOutput(func() {
+				rootCmd.SetArgs(append([]string{"update"}, args...))
+				assert.NoError(t, rootCmd.Execute())
+			})
+		}
+		logs := func() (string, string) {
+			once, _ := os.ReadFile(filepath.Join(homePath, "once.log"))
+			fonts, _ := os.ReadFile(filepath.Join(homePath, "fonts.log"))
+			return string(once), string(fonts)
+		}
+
+		// A dry run lists the scripts without running them
+		output := update("--dry-run")
+		updateCmd.Flags().Set("dry-run", "false")
+		assert.Contains(t, output, "R\t"+filepath.Join(".scadufax", "scripts", "run_once_plugins.sh"))
+		assert.Contains(t, output, "R\t"+filepath.Join(".scadufax", "scripts", "run_onchange_fonts.sh"))
+		assert.NotContains(t, output, "helpers.sh")
+		assert.NotContains(t, output, "run_once_never.sh")
+		once, fonts := logs()
+		assert.Empty(t, once)
+		assert.Empty(t, fonts)
+
+		// And records them as R, with what they render to
+		output = captureOutput(func() {
+			rootCmd.SetArgs([]string{"update", "--dry-run", "--output", "json"})
+			assert.NoError(t, rootCmd.Execute())
+		})
+		updateCmd.Flags().Set("dry-run", "false")
+		rootCmd.PersistentFlags().Set("output", "text")
+		var all, records []fileStatus
+		require.NoError(t, json.Unmarshal([]byte(output), &all), output)
+		for _, r := range all {
+			if r.Status == "R" {
+				records = append(records, r)
+			}
+		}
+		require.Len(t, records, 2)
+		for i, name := range []string{"run_once_plugins.sh", "run_onchange_fonts.sh"} {
+			assert.Equal(t, ".scadufax/scripts/"+name, records[i].Path)
+			assert.Equal(t, "R", records[i].Status)
+			assert.Equal(t, "fork", records[i].Branch)
+			assert.Nil(t, records[i].Target)
+		}
+		rendered := "#!/bin/sh\necho v1 >> fonts.log\n"
+		assert.Equal(t, &fileContent{SHA256: state.Sum([]byte(rendered)), Size: int64(len(rendered))}, records[1].Source)
+
+		update()
+		once, fonts = logs()
+		assert.Equal(t, "once\n", once)
+		assert.Equal(t, "v1\n", fonts)
+
+		// Nothing runs twice
+		output = update()
+		assert.Contains(t, output, "Home is up to date")
+		once, fonts = logs()
+		assert.Equal(t, "once\n", once)
+		assert.Equal(t, "v1\n", fonts)
+
+		// Until its rendered content changes
+		viper.Set("root.fonts", "v2")
+		output = update()
+		assert.NotContains(t, output, "run_once_plugins.sh")
+		once, fonts = logs()
+		assert.Equal(t, "once\n", once)
+		assert.Equal(t, "v1\nv2\n", fonts)
+	})
+
+	t.Run("Update_Three_Way_Drift", func(t *testing.T) {
+		gitops.Checkout(localPath, "fork")
+		check := func() string {
+			return captureOutput(func() {
+				rootCmd.SetArgs([]string{"check", "--local", "--full=false"})
+				assert.NoError(t, rootCmd.Execute())
+			})
+		}
+		homeFile := filepath.Join(homePath, "file.txt")
+
+		// file.txt was installed as "v2" by an earlier update
+		require.NoError(t, os.WriteFile(homeFile, []byte("local"), 0644))
+		assert.Contains(t, check(), "M\tfile.txt")
+
+		// The repository moved, home did not
+		require.NoError(t, os.WriteFile(homeFile, []byte("v2"), 0644))
+		os.WriteFile(filepath.Join(localPath, "file.txt"), []byte("v3"), 0644)
+		w.Add("file.txt")
+		w.Commit("Update v3", &git.CommitOptions{Author: &object.Signature{Name: "T", Email: "t", When: time.Now()}})
+		assert.Contains(t, check(), "U\tfile.txt")
+
+		// Both moved
+		require.NoError(t, os.WriteFile(homeFile, []byte("local"), 0644))
+		assert.Contains(t, check(), "C\tfile.txt")
+
+		update := func(input string) string {
+			r, wPipe, _ := os.Pipe()
+			oldStdin := os.Stdin
+			defer func() { os.Stdin = oldStdin }()
+			os.Stdin = r
+			wPipe.Write([]byte(input))
+			wPipe.Close()
+			return captureOutput(func() {
+				rootCmd.SetArgs([]string{"update"})
+				assert.NoError(t, rootCmd.Execute())
+			})
+		}
+
+		// Confirming the update does not overwrite the change in home
+		output := update("y\n\n")
+		assert.Contains(t, output, "C\tfile.txt")
+		assert.Contains(t, output, "file.txt was changed in home and in the repository. Overwrite it? [y/N]: Keeping file.txt.")
+		content, err := os.ReadFile(homeFile)
+		require.NoError(t, err)
+		assert.Equal(t, "local", string(content))
+
+		// Confirming the file too settles it
+		update("y\ny\n")
+		assert.NotContains(t, check(), "file.txt")
+
+		require.NoError(t, os.WriteFile(homeFile, []byte("local"), 0644))
+		assert.Contains(t, check(), "M\tfile.txt")
+		require.NoError(t, os.WriteFile(homeFile, []byte("v3"), 0644))
+	})
+
+	t.Run("Update_JSON", func(t *testing.T) {
+		gitops.Checkout(localPath, "fork")
+		require.NoError(t, os.WriteFile(filepath.Join(homePath, "file.txt"), []byte("local"), 0644))
+		defer os.WriteFile(filepath.Join(homePath, "file.txt"), []byte("v3"), 0644)
+		defer rootCmd.PersistentFlags().Set("output", "text")
+
+		// Only records reach stdout
+		records := func(args ...string) []fileStatus {
+			output := captureOutput(func() {
+				rootCmd.SetArgs(append(args, "--output", "json"))
+				assert.NoError(t, rootCmd.Execute())
+			})
+			var records []fileStatus
+			require.NoError(t, json.Unmarshal([]byte(output), &records), output)
+			return records
+		}
+
+		for _, got := range [][]fileStatus{
+			records("check", "--local", "--full=false", "--all=false"),
+			records("update", "--dry-run"),
+		} {
+			require.Len(t, got, 1)
+			assert.Equal(t, "file.txt", got[0].Path)
+			assert.Equal(t, "M", got[0].Status)
+			assert.Equal(t, "fork", got[0].Branch)
+			assert.Equal(t, state.Sum([]byte("v3")), got[0].Source.SHA256)
+			assert.Equal(t, state.Sum([]byte("local")), got[0].Target.SHA256)
+			assert.Equal(t, int64(len("local")), got[0].Target.Size)
+		}
+		updateCmd.Flags().Set("dry-run", "false")
+	})
+
+	t.Run("Update_Pre_Update_Hook", func(t *testing.T) {
+		origin, err := git.PlainOpen(originPath)
+		require.NoError(t, err)
+		originMain := func() plumbing.Hash {
+			ref, err := origin.Reference(plumbing.ReferenceName("refs/heads/main"), true)
+			require.NoError(t, err)
+			return ref.Hash()
+		}
+
+		// A commit on main not pushed yet
+		gitops.Checkout(localPath, "main")
+		require.NoError(t, os.WriteFile(filepath.Join(localPath, "main.txt"), []byte("main"), 0644))
+		w.Add("main.txt")
+		w.Commit("Main only\n\nSCADUFAX_ID: main-id", &git.CommitOptions{Author: &object.Signature{Name: "T", Email: "t", When: time.Now()}})
+		pushed := originMain()
+
+		// A failing hook stops the update before main is pushed
+		viper.Set("hooks.pre_update", "exit 1")
+		defer viper.Set("hooks.pre_update", nil)
+		rootCmd.SetArgs([]string{"update"})
+		err = rootCmd.Execute()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "pre_update hook")
+		assert.Equal(t, pushed, originMain())
+
+		log := filepath.Join(rootDir, "hooks.log")
+		viper.Set("hooks.pre_update", `echo "$SCADUFAX_HOOK $SCADUFAX_ID" >> `+log)
+		rootCmd.SetArgs([]string{"update"})
+		require.NoError(t, rootCmd.Execute())
+		assert.NotEqual(t, pushed, originMain())
+
+		content, err := os.ReadFile(log)
+		require.NoError(t, err)
+		assert.Equal(t, "pre_update main-id\n", string(content))
+	})
 }
@@ -2,12 +2,333 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 
-	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"github.com/suderio/scadufax/pkg/crypt"
+	"github.com/suderio/scadufax/pkg/manifest"
+	"github.com/suderio/scadufax/pkg/processor"
+	"github.com/suderio/scadufax/pkg/secrets"
+	"github.com/suderio/scadufax/pkg/vault"
 )
 
-// GenerateCommitMessage appends a unique SCADUFAX_ID to the commit message.
-func GenerateCommitMessage(msg string) string {
-	id := uuid.New().String()
+// commitMessage appends the SCADUFAX_ID id to the commit message.
+func commitMessage(msg, id string) string {
 	return fmt.Sprintf("%s\n\nSCADUFAX_ID: %s", msg, id)
 }
+
+// isMetaPath reports whether rel (relative to the repository root) lives in
+// scadufax's own directory, which is never installed into home.
+func isMetaPath(rel string) bool {
+	return rel == processor.MetaDir || strings.HasPrefix(rel, processor.MetaDir+string(filepath.Separator))
+}
+
+// loadIncludes loads the shared templates of the repository at repoDir,
+// including the overrides for fork.
+func loadIncludes(repoDir, fork string) (processor.Option, error) {
+	set, err := processor.LoadIncludes(filepath.Join(repoDir, processor.IncludesDir), fork)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load includes: %w", err)
+	}
+	return processor.WithIncludes(set), nil
+}
+
+// templateSuffix returns the configured opt-in template suffix, or "" when
+// every file in the repository is a template.
+func templateSuffix() string {
+	return viper.GetString("scadufax.template_suffix")
+}
+
+// repoRelFor maps a path relative to home to the repository file tracking
+// it. An alternate selected for this machine wins, then, with opt-in
+// templating, the template variant (rel + suffix). If none exists rel is
+// returned unchanged.
+func repoRelFor(localDir, rel string) string {
+	if selected, ok := siblingAlternates(localDir, rel).Selected(filepath.ToSlash(rel)); ok {
+		return filepath.FromSlash(selected)
+	}
+	if suffix := templateSuffix(); suffix != "" {
+		if _, err := os.Stat(filepath.Join(localDir, rel+suffix)); err == nil {
+			return rel + suffix
+		}
+	}
+	return rel
+}
+
+// newSecrets builds the backends behind the `secret` template function: the
+// builtin "dotenv" backend reading envPath, the builtin "env" backend, the
+// builtin "vault" backend if a vault exists, and whatever is configured under
+// [secrets]. Unless secrets.default names another one, dotenv is the default
+// backend, so that creating a vault does not move existing keys away.
+func newSecrets(envPath string, required bool) (*secrets.Registry, error) {
+	dotenv := secrets.NewDotenv(envPath, required)
+
+	var cfg secrets.Config
+	if err := viper.UnmarshalKey("secrets", &cfg); err != nil {
+		return nil, fmt.Errorf("invalid [secrets] configuration: %w", err)
+	}
+
+	builtins := map[string]secrets.Provider{
+		"dotenv": dotenv,
+		"env":    secrets.Env{},
+	}
+	if _, err := os.Stat(vaultPath()); err == nil {
+		builtins["vault"] = vault.NewProvider(vaultPath(), readPassphrase)
+	}
+	return secrets.Build(cfg, builtins, "dotenv")
+}
+
+// preserveSecret is the `secret` function used when secrets must not be
+// injected: it renders the call back as a template action.
+func preserveSecret(key string, backend ...string) (string, error) {
+	if len(backend) > 0 {
+		return fmt.Sprintf("{{ secret %q %q }}", key, backend[0]), nil
+	}
+	return fmt.Sprintf("{{ %q | secret }}", key), nil
+}
+
+// keyPath returns the location of the symmetric key used for files added
+// with `add --encrypt`.
+func keyPath() string {
+	if path := viper.GetString("scadufax.key_file"); path != "" {
+		return path
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".config", "scadufax", "key")
+}
+
+// loadKey returns the symmetric key, or nil if this machine has none.
+func loadKey() ([]byte, error) {
+	if _, err := os.Stat(keyPath()); os.IsNotExist(err) {
+		return nil, nil
+	}
+	return crypt.LoadKey(keyPath())
+}
+
+// renderCache returns the option keeping rendered files between runs, in
+// scadufax.cache_dir or the user's cache directory. Without either, every
+// file is rendered on every run.
+func renderCache() processor.Option {
+	dir := viper.GetString("scadufax.cache_dir")
+	if dir == "" {
+		if cache, err := os.UserCacheDir(); err == nil {
+			dir = filepath.Join(cache, "scadufax", "render")
+		}
+	}
+	return processor.WithCache(dir)
+}
+
+// forkKeyPath returns the location of this machine's private key, used to
+// open fork files sealed to it.
+func forkKeyPath() string {
+	if path := viper.GetString("scadufax.fork_key"); path != "" {
+		return path
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".config", "scadufax", "fork.key")
+}
+
+// recipientPath returns where the public key of fork is committed in the
+// repository at repoDir.
+func recipientPath(repoDir, fork string) string {
+	return filepath.Join(repoDir, processor.MetaDir, "recipients", fork+".pub")
+}
+
+// isProtected reports whether the file at path is encrypted, either with
+// the symmetric key or sealed to this machine.
+func isProtected(path string) (bool, error) {
+	encrypted, err := crypt.IsEncryptedFile(path)
+	if err != nil || encrypted {
+		return encrypted, err
+	}
+	return crypt.IsSealedFile(path)
+}
+
+// readDecrypted returns the content of path, decrypted if it is an encrypted
+// or sealed file, and whether it was.
+func readDecrypted(path string) ([]byte, bool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if crypt.IsSealed(content) {
+		kp, err := crypt.LoadKeyPair(forkKeyPath())
+		if err != nil {
+			return nil, true, fmt.Errorf("%s is sealed to this machine: %w", path, err)
+		}
+		plain, err := crypt.Open(kp, content)
+		if err != nil {
+			return nil, true, fmt.Errorf("%s: %w", path, err)
+		}
+		return plain, true, nil
+	}
+
+	if !crypt.IsEncrypted(content) {
+		return content, false, nil
+	}
+
+	key, err := loadKey()
+	if err != nil {
+		return nil, true, err
+	}
+	if key == nil {
+		return nil, true, fmt.Errorf("%s is encrypted but there is no key at %s", path, keyPath())
+	}
+	plain, err := crypt.Decrypt(key, content)
+	if err != nil {
+		return nil, true, fmt.Errorf("%s: %w", path, err)
+	}
+	return plain, true, nil
+}
+
+// installFile copies a repository file into home, decrypting it if needed.
+// Decrypted files are only readable by the owner, and links stay links.
+func installFile(src, dst string) error {
+	if isSymlink(src) {
+		return copyLink(src, dst)
+	}
+	protected, err := isProtected(src)
+	if err != nil {
+		return err
+	}
+	if !protected {
+		return copyFile(src, dst)
+	}
+
+	content, _, err := readDecrypted(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(dst, content, 0600); err != nil {
+		return err
+	}
+	return os.Chmod(dst, 0600)
+}
+
+// resolvedFork returns the configured fork name, defaulting to the hostname.
+func resolvedFork() string {
+	forkName := viper.GetString("scadufax.fork")
+	if forkName == "" {
+		forkName, _ = os.Hostname()
+	}
+	return forkName
+}
+
+// templateData returns the data templates and manifest conditions are
+// evaluated with: the whole configuration plus a "sys" table describing
+// this machine (os, arch, hostname and fork). Values set under [sys] in the
+// configuration win, so a pipeline can render for another machine.
+func templateData() map[string]any {
+	hostname, _ := os.Hostname()
+	return withSys(viper.AllSettings(), map[string]any{
+		"os":       runtime.GOOS,
+		"arch":     runtime.GOARCH,
+		"hostname": hostname,
+		"fork":     resolvedFork(),
+	})
+}
+
+// withSys sets the "sys" table of data to defaults, overridden by whatever
+// data already has under "sys".
+func withSys(data, defaults map[string]any) map[string]any {
+	if configured, ok := data["sys"].(map[string]any); ok {
+		for k, v := range configured {
+			defaults[k] = v
+		}
+	}
+	data["sys"] = defaults
+	return data
+}
+
+// fileFilter loads the manifest of the repository at repoDir and returns
+// its conditions evaluated for this machine.
+func fileFilter(repoDir string) (processor.Filter, error) {
+	return manifestFilter(repoDir, templateData())
+}
+
+// manifestFilter loads the manifest of the repository at repoDir and returns
+// its conditions evaluated with data.
+func manifestFilter(repoDir string, data map[string]any) (processor.Filter, error) {
+	m, err := manifest.Load(filepath.Join(repoDir, processor.MetaDir, manifest.FileName))
+	if err != nil {
+		return nil, err
+	}
+	return m.Filter(data, processor.Funcs()), nil
+}
+
+// manifestDelims returns the option giving every file of the repository at
+// repoDir the template delimiters its manifest sets for it.
+func manifestDelims(repoDir string) (processor.Option, error) {
+	m, err := manifest.Load(filepath.Join(repoDir, processor.MetaDir, manifest.FileName))
+	if err != nil {
+		return nil, err
+	}
+	return processor.WithFileDelims(m.Delims), nil
+}
+
+// delimsFor returns the option giving the file installed as target the
+// template delimiters the manifest of the repository at repoDir sets for it.
+func delimsFor(repoDir, target string) (processor.Option, error) {
+	m, err := manifest.Load(filepath.Join(repoDir, processor.MetaDir, manifest.FileName))
+	if err != nil {
+		return nil, err
+	}
+	return processor.WithDelims(m.Delims(filepath.ToSlash(target))), nil
+}
+
+// machine describes this machine for selecting alternates, from the same
+// "sys" table templates see.
+func machine() processor.Machine {
+	return machineOf(templateData())
+}
+
+// machineOf describes the machine data is for, from its "sys" table.
+func machineOf(data map[string]any) processor.Machine {
+	sys, _ := data["sys"].(map[string]any)
+	get := func(key string) string {
+		value, _ := sys[key].(string)
+		return value
+	}
+	return processor.Machine{
+		Fork:     get("fork"),
+		OS:       get("os"),
+		Arch:     get("arch"),
+		Hostname: get("hostname"),
+	}
+}
+
+// findAlternates selects the alternates of the tree at root for this
+// machine. suffix is the template suffix files in the tree may carry.
+func findAlternates(root, suffix string) (*processor.Alternates, error) {
+	return findAlternatesFor(root, suffix, machine())
+}
+
+// findAlternatesFor selects the alternates of the tree at root for m.
+func findAlternatesFor(root, suffix string, m processor.Machine) (*processor.Alternates, error) {
+	alts, err := processor.FindAlternates(root, suffix, m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find alternates: %w", err)
+	}
+	return alts, nil
+}
+
+// siblingAlternates selects, for this machine, among the alternates in the
+// repository directory holding rel.
+func siblingAlternates(localDir, rel string) *processor.Alternates {
+	dir := filepath.Dir(rel)
+	entries, _ := os.ReadDir(filepath.Join(localDir, dir))
+	var rels []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.Contains(entry.Name(), processor.AltSep) {
+			rels = append(rels, filepath.ToSlash(filepath.Join(dir, entry.Name())))
+		}
+	}
+	return processor.NewAlternates(rels, templateSuffix(), machine())
+}
@@ -0,0 +1,131 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// encryptedHeader starts every file encrypted with a symmetric key. The
+// header is also bound to the ciphertext as additional data.
+const encryptedHeader = "SCADUFAX-ENCRYPTED-V1\n"
+
+// KeySize is the length of a symmetric key.
+const KeySize = chacha20poly1305.KeySize
+
+// ErrDecrypt is returned when data cannot be decrypted with the given key.
+var ErrDecrypt = errors.New("failed to decrypt: wrong key or corrupted data")
+
+// GenerateKey returns a new random symmetric key.
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// LoadKey reads a base64 encoded symmetric key from path.
+func LoadKey(path string) ([]byte, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key %s: %w", path, err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil || len(key) != KeySize {
+		return nil, fmt.Errorf("invalid key in %s", path)
+	}
+	return key, nil
+}
+
+// LoadOrCreateKey reads the key at path, generating it (with 0600
+// permissions) if it does not exist yet.
+func LoadOrCreateKey(path string) ([]byte, error) {
+	if _, err := os.Stat(path); err == nil {
+		return LoadKey(path)
+	}
+
+	key, err := GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(key) + "\n"
+	if err := os.WriteFile(path, []byte(encoded), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write key %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// Encrypt seals plain with key (XChaCha20-Poly1305).
+func Encrypt(key, plain []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append([]byte(encryptedHeader), nonce...)
+	return aead.Seal(out, nonce, plain, []byte(encryptedHeader)), nil
+}
+
+// Decrypt opens data produced by Encrypt.
+func Decrypt(key, data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, fmt.Errorf("data is not encrypted")
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	body := data[len(encryptedHeader):]
+	if len(body) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	plain, err := aead.Open(nil, body[:aead.NonceSize()], body[aead.NonceSize():], []byte(encryptedHeader))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plain, nil
+}
+
+// IsEncrypted reports whether data was produced by Encrypt.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(encryptedHeader))
+}
+
+// IsEncryptedFile reports whether the file at path was produced by Encrypt,
+// reading only its header.
+func IsEncryptedFile(path string) (bool, error) {
+	return hasHeader(path, encryptedHeader)
+}
+
+func hasHeader(path, header string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, len(header))
+	if _, err := io.ReadFull(f, buf); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+		return false, err
+	}
+	return string(buf) == header, nil
+}
@@ -0,0 +1,129 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// sealedHeader starts every file sealed to a machine's public key.
+const sealedHeader = "SCADUFAX-SEALED-V1\n"
+
+// KeyPair is a machine's X25519 key pair. Anyone holding Public can seal
+// data that only the holder of Private can open.
+type KeyPair struct {
+	Public  *[32]byte
+	Private *[32]byte
+}
+
+// GenerateKeyPair returns a new random key pair.
+func GenerateKeyPair() (*KeyPair, error) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyPair{Public: pub, Private: priv}, nil
+}
+
+// LoadKeyPair reads a base64 encoded private key from path and derives its
+// public key.
+func LoadKeyPair(path string) (*KeyPair, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key %s: %w", path, err)
+	}
+	priv, err := decodeKey(string(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key in %s", path)
+	}
+	pub := new([32]byte)
+	derived, err := curve25519.X25519(priv[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	copy(pub[:], derived)
+	return &KeyPair{Public: pub, Private: priv}, nil
+}
+
+// Save writes the private key to path with 0600 permissions.
+func (kp *KeyPair) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(encodeKey(kp.Private)), 0600); err != nil {
+		return fmt.Errorf("failed to write private key %s: %w", path, err)
+	}
+	return nil
+}
+
+// EncodePublicKey returns the text form of a public key, as stored in the
+// repository.
+func EncodePublicKey(pub *[32]byte) string {
+	return encodeKey(pub)
+}
+
+func encodeKey(key *[32]byte) string {
+	return base64.StdEncoding.EncodeToString(key[:]) + "\n"
+}
+
+// LoadPublicKey reads a public key written with EncodePublicKey.
+func LoadPublicKey(path string) (*[32]byte, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key %s: %w", path, err)
+	}
+	pub, err := decodeKey(string(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key in %s", path)
+	}
+	return pub, nil
+}
+
+func decodeKey(text string) (*[32]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(text))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != 32 {
+		return nil, fmt.Errorf("expected 32 bytes, got %d", len(b))
+	}
+	key := new([32]byte)
+	copy(key[:], b)
+	return key, nil
+}
+
+// Seal encrypts plain so that only the holder of the private key matching
+// recipient can open it.
+func Seal(recipient *[32]byte, plain []byte) ([]byte, error) {
+	return box.SealAnonymous([]byte(sealedHeader), plain, recipient, rand.Reader)
+}
+
+// Open decrypts data produced by Seal.
+func Open(kp *KeyPair, data []byte) ([]byte, error) {
+	if !IsSealed(data) {
+		return nil, fmt.Errorf("data is not sealed")
+	}
+	plain, ok := box.OpenAnonymous(nil, data[len(sealedHeader):], kp.Public, kp.Private)
+	if !ok {
+		return nil, ErrDecrypt
+	}
+	return plain, nil
+}
+
+// IsSealed reports whether data was produced by Seal.
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(sealedHeader))
+}
+
+// IsSealedFile reports whether the file at path was produced by Seal,
+// reading only its header.
+func IsSealedFile(path string) (bool, error) {
+	return hasHeader(path, sealedHeader)
+}
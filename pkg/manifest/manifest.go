@@ -0,0 +1,255 @@
+// Package manifest reads .scadufax/manifest.toml, the committed list of
+// per-file rules of a dotfiles repository.
+package manifest
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// FileName is the name of the manifest inside the repository's meta
+// directory.
+const FileName = "manifest.toml"
+
+// Install modes of a file, set with mode.
+const (
+	// ModeCopy installs a copy of the file in home.
+	ModeCopy = "copy"
+	// ModeSymlink installs the file outside home and links home to it.
+	ModeSymlink = "symlink"
+)
+
+// Manifest holds the rules of a repository.
+//
+//	[[file]]
+//	path = ".config/i3/**"
+//	when = 'eq .sys.os "linux"'
+//
+//	[[file]]
+//	path = ".ssh/config"
+//	when = 'hasPrefix "server-" .sys.fork'
+//
+//	[[file]]
+//	path = ".config/helm/**"
+//	delims = "[[ ]]"
+//
+//	[[file]]
+//	path = ".ssh/**"
+//	perm = "0600"
+//
+//	[[file]]
+//	path = ".config/Code/User/settings.json"
+//	mode = "symlink"
+//
+//	[[dir]]
+//	path = ".ssh"
+//	perm = "0700"
+type Manifest struct {
+	Files []File `toml:"file"`
+	Dirs  []Dir  `toml:"dir"`
+}
+
+// File is a rule for every path matching Path: a slash separated pattern,
+// relative to home, where * and ? do not cross directories and ** matches
+// any number of them. A pattern naming a directory covers everything in it.
+//
+// When is a template pipeline, evaluated with the template data as in
+// {{ if ... }}. Files whose condition is false do not belong on the
+// machine. An empty When always holds.
+//
+// Delims replaces the template delimiters of the matching files: the left
+// and right delimiters, separated by a space.
+//
+// Perm is the mode the matching files are installed with, in octal, e.g.
+// "0600". Git only keeps the executable bit, so without it files get the
+// mode of their source.
+//
+// Mode is how the matching files are installed: ModeCopy, the default, or
+// ModeSymlink for tools that replace their files rather than write them.
+type File struct {
+	Path   string `toml:"path"`
+	When   string `toml:"when"`
+	Delims string `toml:"delims"`
+	Perm   string `toml:"perm"`
+	Mode   string `toml:"mode"`
+}
+
+// Dir is a directory to create in home, even when empty, which git cannot
+// track. Path is relative to home; Perm is its mode, in octal, and defaults
+// to "0755". Like files, directories whose conditions do not hold (see
+// Filter) do not belong on the machine.
+type Dir struct {
+	Path string `toml:"path"`
+	Perm string `toml:"perm"`
+}
+
+// Mode returns the mode of the directory.
+func (d Dir) Mode() fs.FileMode {
+	if d.Perm == "" {
+		return 0755
+	}
+	mode, _ := parsePerm(d.Perm)
+	return mode
+}
+
+// Load reads the manifest at path. A missing manifest is empty.
+func Load(path string) (*Manifest, error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Manifest{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var m Manifest
+	if err := toml.Unmarshal(content, &m); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", path, err)
+	}
+	for i, f := range m.Files {
+		if f.Path == "" {
+			return nil, fmt.Errorf("invalid manifest %s: file #%d has no path", path, i+1)
+		}
+		if f.Delims != "" && len(strings.Fields(f.Delims)) != 2 {
+			return nil, fmt.Errorf("invalid manifest %s: delims of %s must be two delimiters separated by a space, got %q", path, f.Path, f.Delims)
+		}
+		if _, err := parsePerm(f.Perm); f.Perm != "" && err != nil {
+			return nil, fmt.Errorf("invalid manifest %s: perm of %s: %w", path, f.Path, err)
+		}
+		if f.Mode != "" && f.Mode != ModeCopy && f.Mode != ModeSymlink {
+			return nil, fmt.Errorf("invalid manifest %s: mode of %s must be %q or %q, got %q", path, f.Path, ModeCopy, ModeSymlink, f.Mode)
+		}
+	}
+	for i, d := range m.Dirs {
+		if strings.Trim(d.Path, "/") == "" {
+			return nil, fmt.Errorf("invalid manifest %s: dir #%d has no path", path, i+1)
+		}
+		if _, err := parsePerm(d.Perm); d.Perm != "" && err != nil {
+			return nil, fmt.Errorf("invalid manifest %s: perm of %s: %w", path, d.Path, err)
+		}
+		m.Dirs[i].Path = strings.Trim(d.Path, "/")
+	}
+	return &m, nil
+}
+
+// parsePerm parses an octal permission, e.g. "0600".
+func parsePerm(s string) (fs.FileMode, error) {
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || n > 0777 {
+		return 0, fmt.Errorf("%q is not an octal mode such as \"0644\"", s)
+	}
+	return fs.FileMode(n), nil
+}
+
+// Perm returns the mode of the file at rel (slash separated, relative to
+// home), set by the last rule matching it, if any.
+func (m *Manifest) Perm(rel string) (fs.FileMode, bool) {
+	var mode fs.FileMode
+	found := false
+	for _, f := range m.Files {
+		if f.Perm != "" && Match(f.Path, rel) {
+			mode, _ = parsePerm(f.Perm)
+			found = true
+		}
+	}
+	return mode, found
+}
+
+// Symlink reports whether the file at rel (slash separated, relative to
+// home) is installed as a link, as set by the last rule matching it.
+func (m *Manifest) Symlink(rel string) bool {
+	mode := ModeCopy
+	for _, f := range m.Files {
+		if f.Mode != "" && Match(f.Path, rel) {
+			mode = f.Mode
+		}
+	}
+	return mode == ModeSymlink
+}
+
+// Delims returns the template delimiters of the file at rel (slash
+// separated, relative to home), set by the last rule matching it. Both are
+// empty when no rule sets them.
+func (m *Manifest) Delims(rel string) (left, right string) {
+	for _, f := range m.Files {
+		if f.Delims != "" && Match(f.Path, rel) {
+			fields := strings.Fields(f.Delims)
+			left, right = fields[0], fields[1]
+		}
+	}
+	return left, right
+}
+
+// Filter returns a function reporting whether the file at rel (slash
+// separated, relative to home) belongs on the machine described by data:
+// whether the conditions of every rule matching it hold. funcs are made
+// available to the conditions.
+func (m *Manifest) Filter(data map[string]any, funcs template.FuncMap) func(rel string) (bool, error) {
+	conds := make([]*template.Template, len(m.Files))
+	results := map[int]bool{}
+
+	return func(rel string) (bool, error) {
+		for i, f := range m.Files {
+			if f.When == "" || !Match(f.Path, rel) {
+				continue
+			}
+			ok, seen := results[i]
+			if !seen {
+				if conds[i] == nil {
+					tmpl, err := template.New(f.Path).Funcs(funcs).Parse("{{ if " + f.When + " }}true{{ end }}")
+					if err != nil {
+						return false, fmt.Errorf("invalid condition for %s: %w", f.Path, err)
+					}
+					conds[i] = tmpl
+				}
+				var buf bytes.Buffer
+				if err := conds[i].Execute(&buf, data); err != nil {
+					return false, fmt.Errorf("failed to evaluate condition for %s: %w", f.Path, err)
+				}
+				ok = buf.String() == "true"
+				results[i] = ok
+			}
+			if !ok {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+}
+
+// Match reports whether rel, or one of the directories containing it,
+// matches pattern.
+func Match(pattern, rel string) bool {
+	pattern = strings.Trim(pattern, "/")
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
+
+func matchSegments(pattern, parts []string) bool {
+	if len(pattern) == 0 {
+		// The pattern matched a directory containing the file
+		return true
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if matchSegments(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], parts[1:])
+}
@@ -0,0 +1,201 @@
+package processor
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// AltSep separates a file name from the conditions selecting it, as in
+// ".gitconfig##os.linux" or ".gitconfig##os.linux,hostname.box". With
+// opt-in templating the template suffix comes last:
+// ".gitconfig##fork.laptop-work.tmpl".
+const AltSep = "##"
+
+// Machine describes the machine alternates are selected for.
+type Machine struct {
+	Fork     string
+	OS       string
+	Arch     string
+	Hostname string
+}
+
+// weights of the conditions, so that the most specific variant wins. A
+// combination scores the sum of its conditions.
+var altWeights = map[string]int{
+	"fork":     8,
+	"hostname": 4,
+	"os":       2,
+	"arch":     1,
+}
+
+// ParseAlternate splits name (without the template suffix) into the base
+// name the file is installed under and the conditions selecting it. ok is
+// false if name is not an alternate.
+func ParseAlternate(name string) (base string, conds []string, ok bool) {
+	dir, file := path.Split(name)
+	i := strings.Index(file, AltSep)
+	if i <= 0 {
+		return name, nil, false
+	}
+	return dir + file[:i], strings.Split(file[i+len(AltSep):], ","), true
+}
+
+// CheckConditions reports the first of conds no machine could match: one
+// that is neither "default" nor key.value with a known key and a value.
+func CheckConditions(conds []string) error {
+	for _, cond := range conds {
+		if cond == "default" {
+			continue
+		}
+		key, value, found := strings.Cut(cond, ".")
+		if _, known := altWeights[key]; !found || !known || value == "" {
+			return fmt.Errorf("invalid alternate condition %q: want default or fork, hostname, os or arch, as in os.linux", cond)
+		}
+	}
+	return nil
+}
+
+// score returns how specifically conds match m, or -1 if they do not.
+// "default" matches every machine with the lowest score.
+func (m Machine) score(conds []string) int {
+	total := 0
+	for _, cond := range conds {
+		if cond == "default" {
+			continue
+		}
+		key, value, found := strings.Cut(cond, ".")
+		if !found {
+			return -1
+		}
+		var actual string
+		switch key {
+		case "fork":
+			actual = m.Fork
+		case "hostname":
+			actual = m.Hostname
+		case "os":
+			actual = m.OS
+		case "arch":
+			actual = m.Arch
+		default:
+			return -1
+		}
+		if value != actual {
+			return -1
+		}
+		total += altWeights[key]
+	}
+	return total
+}
+
+// Alternates records, for every base name of a tree, its variants and the
+// one selected for a machine. A nil *Alternates has no alternates.
+type Alternates struct {
+	variants map[string][]string // base name -> repository paths
+	selected map[string]string   // base name -> repository path
+	targets  map[string]string   // base name -> selected name, without suffix
+}
+
+// NewAlternates selects among the alternates in rels, slash separated
+// repository paths that may carry the template suffix. Ties between equally
+// specific variants go to the first in lexical order.
+func NewAlternates(rels []string, suffix string, m Machine) *Alternates {
+	a := &Alternates{
+		variants: map[string][]string{},
+		selected: map[string]string{},
+		targets:  map[string]string{},
+	}
+	best := map[string]int{}
+
+	sorted := append([]string(nil), rels...)
+	sort.Strings(sorted)
+	for _, rel := range sorted {
+		target := TargetName(rel, suffix)
+		base, conds, ok := ParseAlternate(target)
+		if !ok {
+			continue
+		}
+		a.variants[base] = append(a.variants[base], rel)
+		score := m.score(conds)
+		if score < 0 {
+			continue
+		}
+		if prev, seen := best[base]; !seen || score > prev {
+			best[base] = score
+			a.selected[base] = rel
+			a.targets[base] = target
+		}
+	}
+	return a
+}
+
+// FindAlternates walks the tree at root, skipping .git and MetaDir, and
+// selects its alternates for m.
+func FindAlternates(root, suffix string, m Machine) (*Alternates, error) {
+	var rels []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" || rel == MetaDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.Contains(d.Name(), AltSep) {
+			rels = append(rels, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewAlternates(rels, suffix, m), nil
+}
+
+// Target maps target, the name of a file without the template suffix, to
+// the name it is installed under. ok is false if the file is not installed
+// on the machine: it is a variant that was not selected, or a plain file
+// shadowed by a selected variant.
+func (a *Alternates) Target(target string) (string, bool) {
+	if a == nil {
+		return target, true
+	}
+	base, _, isAlt := ParseAlternate(target)
+	selected, has := a.targets[base]
+	if !isAlt {
+		return target, !has
+	}
+	if selected != target {
+		return "", false
+	}
+	return base, true
+}
+
+// Selected returns the repository path of the variant of base selected for
+// the machine.
+func (a *Alternates) Selected(base string) (string, bool) {
+	if a == nil {
+		return "", false
+	}
+	rel, ok := a.selected[base]
+	return rel, ok
+}
+
+// Variants returns the repository paths of every variant of base, in
+// lexical order.
+func (a *Alternates) Variants(base string) []string {
+	if a == nil {
+		return nil
+	}
+	return a.variants[base]
+}
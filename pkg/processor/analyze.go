@@ -0,0 +1,141 @@
+package processor
+
+import (
+	"fmt"
+	"text/template/parse"
+)
+
+// SecretRef is a call to the secret function, with literal arguments, found
+// in a template.
+type SecretRef struct {
+	Key     string
+	Backend string
+	// Pos is the location of the call as "name:line:col".
+	Pos string
+}
+
+// FindSecretRefs parses content as a template named name and returns its
+// secret calls, including those inside {{ define }} blocks. Calls whose key
+// is not a string literal cannot be resolved statically and are skipped.
+//
+// content is parsed with the delimiters set by WithDelims, or by its header.
+func FindSecretRefs(name string, content []byte, opts ...Option) ([]SecretRef, error) {
+	o := newOptions(opts)
+	d := delims{o.left, o.right}
+	if header, body, ok := splitHeader(content); ok {
+		// An empty line in place of the header keeps positions as in the file
+		d, content = header, append([]byte("\n"), body...)
+	}
+	trees, err := parseTrees(name, content, d)
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []SecretRef
+	for _, tree := range trees {
+		walkNodes(tree.Root, func(node parse.Node) {
+			pipe, ok := node.(*parse.PipeNode)
+			if !ok {
+				return
+			}
+			for i, cmd := range pipe.Cmds {
+				ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+				if !ok || ident.Ident != "secret" {
+					continue
+				}
+				args := cmd.Args[1:]
+				if i > 0 && len(pipe.Cmds[i-1].Args) == 1 {
+					// Piped value becomes the last argument
+					args = append(args[:len(args):len(args)], pipe.Cmds[i-1].Args[0])
+				}
+				ref := SecretRef{}
+				if len(args) > 0 {
+					ref.Key = stringLiteral(args[0])
+				}
+				if len(args) > 1 {
+					ref.Backend = stringLiteral(args[1])
+				}
+				if ref.Key == "" {
+					continue
+				}
+				ref.Pos, _ = tree.ErrorContext(cmd)
+				refs = append(refs, ref)
+			}
+		})
+	}
+	return refs, nil
+}
+
+// parseTrees parses content with the delimiters d without checking that the
+// functions it calls exist, returning the main tree and every tree it
+// defines.
+func parseTrees(name string, content []byte, d delims) (map[string]*parse.Tree, error) {
+	trees := map[string]*parse.Tree{}
+	tree := parse.New(name)
+	tree.Mode = parse.SkipFuncCheck
+	if _, err := tree.Parse(string(content), d.left, d.right, trees); err != nil {
+		return nil, fmt.Errorf("failed to parse template %s: %w", name, err)
+	}
+	return trees, nil
+}
+
+func stringLiteral(node parse.Node) string {
+	if s, ok := node.(*parse.StringNode); ok {
+		return s.Text
+	}
+	return ""
+}
+
+// walkNodes calls fn for node and, depth first, every node below it.
+func walkNodes(node parse.Node, fn func(parse.Node)) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		fn(n)
+		for _, child := range n.Nodes {
+			walkNodes(child, fn)
+		}
+	case *parse.ActionNode:
+		fn(n)
+		walkNodes(n.Pipe, fn)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		fn(n)
+		for _, cmd := range n.Cmds {
+			walkNodes(cmd, fn)
+		}
+	case *parse.CommandNode:
+		fn(n)
+		for _, arg := range n.Args {
+			walkNodes(arg, fn)
+		}
+	case *parse.ChainNode:
+		fn(n)
+		walkNodes(n.Node, fn)
+	case *parse.IfNode:
+		fn(n)
+		walkBranch(&n.BranchNode, fn)
+	case *parse.RangeNode:
+		fn(n)
+		walkBranch(&n.BranchNode, fn)
+	case *parse.WithNode:
+		fn(n)
+		walkBranch(&n.BranchNode, fn)
+	case *parse.TemplateNode:
+		fn(n)
+		walkNodes(n.Pipe, fn)
+	case nil:
+	default:
+		fn(n)
+	}
+}
+
+func walkBranch(b *parse.BranchNode, fn func(parse.Node)) {
+	walkNodes(b.Pipe, fn)
+	walkNodes(b.List, fn)
+	walkNodes(b.ElseList, fn)
+}
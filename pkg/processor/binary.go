@@ -0,0 +1,130 @@
+package processor
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"unicode/utf8"
+)
+
+// sniffLen is how much of a file is inspected to decide whether it is binary.
+const sniffLen = 8000
+
+// IsBinary reports whether the file at path looks binary: its first block
+// contains a NUL byte or is not valid UTF-8. Binary files are never rendered.
+func IsBinary(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	return isBinaryContent(buf[:n], n == sniffLen), nil
+}
+
+// isBinaryContent applies the IsBinary heuristic to block. If truncated is
+// true, block was cut from a longer file and may end mid-rune.
+func isBinaryContent(block []byte, truncated bool) bool {
+	if bytes.IndexByte(block, 0) >= 0 {
+		return true
+	}
+	if truncated {
+		// Drop a rune split by the block boundary
+		for i := 1; i < utf8.UTFMax && i <= len(block); i++ {
+			if utf8.RuneStart(block[len(block)-i]) {
+				if !utf8.FullRune(block[len(block)-i:]) {
+					block = block[:len(block)-i]
+				}
+				break
+			}
+		}
+	}
+	return !utf8.Valid(block)
+}
+
+// CopyFile streams src to dst without loading it into memory, creating the
+// parent directory and preserving the permission bits of src.
+func CopyFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	// OpenFile only applies the mode to new files
+	return os.Chmod(dst, info.Mode().Perm())
+}
+
+// SameContent reports whether the files at a and b hold identical bytes,
+// comparing them block by block.
+func SameContent(a, b string) (bool, error) {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	if infoA.Size() != infoB.Size() {
+		return false, nil
+	}
+
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	bufA := make([]byte, 32*1024)
+	bufB := make([]byte, 32*1024)
+	for {
+		nA, errA := io.ReadFull(fa, bufA)
+		nB, errB := io.ReadFull(fb, bufB)
+		if !bytes.Equal(bufA[:nA], bufB[:nB]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
+		}
+		if errA != nil {
+			return false, errA
+		}
+		if errB != nil {
+			return false, errB
+		}
+	}
+}
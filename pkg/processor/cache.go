@@ -0,0 +1,95 @@
+package processor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// cacheVersion is part of every cache key. Bump it whenever what a template
+// renders to may change for the same input, as when a function of funcs.go
+// changes, or when cacheEntry does, so that older entries are not reused.
+const cacheVersion = 1
+
+// renderCache keeps rendered files on disk, keyed by everything their
+// output depends on, so unchanged files are not rendered again. Entries may
+// hold secrets, so they are only readable by the owner.
+type renderCache struct {
+	dir string
+}
+
+// cacheEntry is a rendered file and the secrets it read.
+type cacheEntry struct {
+	Secrets []secretUse `json:"secrets"`
+	Output  []byte      `json:"output"`
+}
+
+// secretUse is a call to the secret function, with a hash of its result.
+type secretUse struct {
+	Key     string `json:"key"`
+	Backend string `json:"backend,omitempty"`
+	Sum     string `json:"sum"`
+}
+
+func sum(b []byte) string {
+	s := sha256.Sum256(b)
+	return hex.EncodeToString(s[:])
+}
+
+func (c *renderCache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".json")
+}
+
+// get returns the entry stored under key if every secret it read still has
+// the same value, as reported by lookup.
+func (c *renderCache) get(key string, lookup func(secretUse) (string, error)) (*cacheEntry, bool) {
+	if c == nil {
+		return nil, false
+	}
+	raw, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		return nil, false
+	}
+	for _, use := range entry.Secrets {
+		value, err := lookup(use)
+		if err != nil || sum([]byte(value)) != use.Sum {
+			return nil, false
+		}
+	}
+	return &entry, true
+}
+
+// put stores entry under key. The cache is best effort: failures are
+// ignored, the file is simply rendered again next time.
+func (c *renderCache) put(key string, entry *cacheEntry) {
+	if c == nil {
+		return
+	}
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".entry-*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		return
+	}
+	os.Rename(tmp.Name(), path)
+}
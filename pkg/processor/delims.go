@@ -0,0 +1,47 @@
+package processor
+
+import (
+	"bytes"
+	"regexp"
+)
+
+// delims are the template delimiters of a file. Empty ones fall back to
+// those of the Renderer, then to "{{" and "}}".
+type delims struct {
+	left, right string
+}
+
+// delimsHeaderRe matches a first line setting the delimiters of a file, in
+// whatever comment syntax the file uses:
+//
+//	# scadufax: delims="[[ ]]"
+var delimsHeaderRe = regexp.MustCompile(`scadufax:\s*delims\s*=\s*"(\S+)\s+(\S+)"`)
+
+// splitHeader returns the delimiters set by the first line of content, if
+// any, and content without that line.
+func splitHeader(content []byte) (delims, []byte, bool) {
+	line, rest, _ := bytes.Cut(content, []byte("\n"))
+	m := delimsHeaderRe.FindSubmatch(line)
+	if m == nil {
+		return delims{}, content, false
+	}
+	return delims{string(m[1]), string(m[2])}, rest, true
+}
+
+// WithDelims replaces the template delimiters "{{" and "}}" of every file.
+func WithDelims(left, right string) Option {
+	return func(o *options) {
+		o.left, o.right = left, right
+	}
+}
+
+// WithFileDelims makes Reify and ReifyTree ask fn for the delimiters of each
+// file, by target name (see Filter). Empty delimiters keep the default ones.
+//
+// Either way, a file whose first line holds `scadufax: delims="L R"` is
+// parsed with L and R, and that line is left out of its output.
+func WithFileDelims(fn func(target string) (left, right string)) Option {
+	return func(o *options) {
+		o.fileDelims = fn
+	}
+}
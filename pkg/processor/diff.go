@@ -0,0 +1,71 @@
+package processor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UnifiedDiff returns the changes from a to b as a unified diff, with
+// context lines of context around each change and from and to naming the
+// two sides in its header. Like the rest of the line diffing here, it does
+// not tell a file from the same file without a newline at the end. It
+// returns nothing when a and b hold the same lines.
+func UnifiedDiff(a, b []byte, from, to string, context int) []byte {
+	blocks := diffBlocks(a, b)
+	if len(blocks) == 0 {
+		return nil
+	}
+	aLines := terminated(splitLines(a))
+	bLines := terminated(splitLines(b))
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", from, to)
+	for len(blocks) > 0 {
+		// Changes whose context would touch share a hunk
+		n := 1
+		for n < len(blocks) && blocks[n].a0-blocks[n-1].a1 <= 2*context {
+			n++
+		}
+		group := blocks[:n]
+		blocks = blocks[n:]
+
+		first, last := group[0], group[len(group)-1]
+		before := min(context, first.a0)
+		after := min(context, len(aLines)-last.a1)
+		a0, a1 := first.a0-before, last.a1+after
+		b0, b1 := first.b0-before, last.b1+after
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(a0, a1), hunkRange(b0, b1))
+
+		i := a0
+		for _, h := range group {
+			for ; i < h.a0; i++ {
+				sb.WriteString(" " + aLines[i])
+			}
+			for _, line := range aLines[h.a0:h.a1] {
+				sb.WriteString("-" + line)
+			}
+			for _, line := range bLines[h.b0:h.b1] {
+				sb.WriteString("+" + line)
+			}
+			i = h.a1
+		}
+		for ; i < a1; i++ {
+			sb.WriteString(" " + aLines[i])
+		}
+	}
+	return []byte(sb.String())
+}
+
+// hunkRange formats the lines [start, end) for a hunk header: the first
+// line, counting from 1, and how many there are. An empty range names the
+// line before it.
+func hunkRange(start, end int) string {
+	switch n := end - start; n {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprint(start + 1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, n)
+	}
+}
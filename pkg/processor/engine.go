@@ -0,0 +1,403 @@
+package processor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"text/template"
+
+	"github.com/suderio/scadufax/pkg/crypt"
+)
+
+// job is one file to reify.
+type job struct {
+	src, dst string
+	// rel and target are the source and target names, relative to the trees
+	rel, target string
+	act         Action
+	// move removes src once dst is written, when reifying in place.
+	move bool
+	// perm is the mode of a rendered file, or the mode set for any other
+	// (see WithPerms). Zero keeps the mode of the source when copying, 0600
+	// when decrypting, and otherwise the mode of an existing dst, or 0644.
+	perm fs.FileMode
+	// delims of the file, unless its header sets others
+	delims delims
+}
+
+// result is the output of a job, computed before anything is written.
+type result struct {
+	out []byte
+	// sensitive is set when the output holds secrets or decrypted data.
+	sensitive bool
+	err       error
+}
+
+// engine renders files concurrently. The template set and function library
+// are built once and cloned for every file.
+type engine struct {
+	o        *options
+	data     map[string]any
+	base     *template.Template
+	cache    *renderCache
+	stateSum string
+
+	// Secret backends (vault, commands) are not safe for concurrent use
+	secretMu sync.Mutex
+	secretFn SecretFunc
+}
+
+func newEngine(data map[string]any, secretFn SecretFunc, o *options) (*engine, error) {
+	e := &engine{o: o, data: data, secretFn: secretFn}
+
+	if o.includes != nil {
+		set, err := o.includes.Clone()
+		if err != nil {
+			return nil, fmt.Errorf("failed to clone includes: %w", err)
+		}
+		e.base = set
+	} else {
+		e.base = template.New("").Funcs(parseFuncs())
+	}
+	e.base.Funcs(promptFuncs(data)).Funcs(o.funcs).Delims(o.left, o.right).Option(o.missingKey())
+
+	// What custom functions return cannot be hashed: with any, every file
+	// is rendered on every run
+	if o.cacheDir != "" && len(o.funcs) == 0 {
+		e.cache = &renderCache{dir: o.cacheDir}
+
+		// Everything besides the file itself that its output depends on
+		h := sha256.New()
+		fmt.Fprintf(h, "%d\n%#v\n%q %q %s\n", cacheVersion, data, o.left, o.right, o.missingKey())
+		if o.includes != nil {
+			templates := o.includes.Templates()
+			sort.Slice(templates, func(i, j int) bool { return templates[i].Name() < templates[j].Name() })
+			for _, t := range templates {
+				if t.Tree != nil {
+					fmt.Fprintf(h, "%s\n%s\n", t.Name(), t.Tree.Root.String())
+				}
+			}
+		}
+		e.stateSum = fmt.Sprintf("%x", h.Sum(nil))
+	}
+	return e, nil
+}
+
+// secret calls the secret function, one call at a time.
+func (e *engine) secret(key string, backend ...string) (string, error) {
+	e.secretMu.Lock()
+	defer e.secretMu.Unlock()
+	return e.secretFn(key, backend...)
+}
+
+// render executes content as the template name, with the delimiters d
+// unless its header sets others, reusing a cached output when the file, the
+// data, the includes and the secrets it read are all unchanged. It also
+// reports whether the template called the secret function.
+func (e *engine) render(name string, content []byte, d delims) ([]byte, bool, error) {
+	if header, body, ok := splitHeader(content); ok {
+		d, content = header, body
+	}
+
+	key := ""
+	if e.cache != nil {
+		key = sum(append([]byte(e.stateSum+"\n"+d.left+" "+d.right+"\n"), content...))
+		entry, ok := e.cache.get(key, func(use secretUse) (string, error) {
+			if use.Backend == "" {
+				return e.secret(use.Key)
+			}
+			return e.secret(use.Key, use.Backend)
+		})
+		if ok {
+			e.o.logger.Debug("render", "template", name, "cached", true)
+			return entry.Output, len(entry.Secrets) > 0, nil
+		}
+	}
+
+	set, err := e.base.Clone()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to clone includes for %s: %w", name, err)
+	}
+	tmpl := set.New(filepath.Base(name))
+	if d.left != "" || d.right != "" {
+		tmpl.Delims(d.left, d.right)
+	}
+
+	var uses []secretUse
+	tmpl.Funcs(template.FuncMap{
+		"secret": func(key string, backend ...string) (string, error) {
+			value, err := e.secret(key, backend...)
+			if err != nil {
+				return "", err
+			}
+			use := secretUse{Key: key, Sum: sum([]byte(value))}
+			if len(backend) > 0 {
+				use.Backend = backend[0]
+			}
+			uses = append(uses, use)
+			return value, nil
+		},
+		"include": includeFunc(tmpl),
+	})
+
+	if _, err := tmpl.Parse(string(content)); err != nil {
+		return nil, false, fmt.Errorf("failed to parse template %s: %w", name, err)
+	}
+	if err := applyOverrides(tmpl); err != nil {
+		return nil, false, fmt.Errorf("failed to apply overrides to %s: %w", name, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, e.data); err != nil {
+		return nil, false, fmt.Errorf("failed to execute template %s: %w", name, err)
+	}
+
+	e.o.logger.Debug("render", "template", name, "cached", false)
+	if e.cache != nil {
+		e.cache.put(key, &cacheEntry{Secrets: uses, Output: buf.Bytes()})
+	}
+	return buf.Bytes(), len(uses) > 0, nil
+}
+
+// run computes the result of every job with a bounded pool of workers.
+func (e *engine) run(jobs []job) []result {
+	results := make([]result, len(jobs))
+
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(e.o.workers, len(jobs)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				results[i] = e.prepare(jobs[i])
+			}
+		}()
+	}
+	for i := range jobs {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	return results
+}
+
+// prepare computes the output of j without writing anything.
+func (e *engine) prepare(j job) result {
+	switch j.act {
+	case ActionRender:
+		content, err := os.ReadFile(j.src)
+		if err != nil {
+			return result{err: err}
+		}
+		out, usedSecrets, err := e.render(j.src, content, j.delims)
+		return result{out: out, sensitive: usedSecrets, err: err}
+
+	case ActionDecrypt:
+		if e.o.key == nil {
+			return result{err: fmt.Errorf("%s is encrypted but no key is configured", j.src)}
+		}
+		raw, err := os.ReadFile(j.src)
+		if err != nil {
+			return result{err: err}
+		}
+		content, err := crypt.Decrypt(e.o.key, raw)
+		if err != nil {
+			return result{err: fmt.Errorf("%s: %w", j.src, err)}
+		}
+		plain := content
+		if !isBinaryContent(content, false) && IsTemplate(j.src, e.o.suffix) {
+			if content, _, err = e.render(j.src, content, j.delims); err != nil {
+				return result{err: err}
+			}
+		}
+		// In place, the tree is committed: plain text never lands in it. An
+		// encrypted file stays encrypted unless it is sealed, which it cannot
+		// be when it renders to something else
+		if j.move && e.o.recipient == nil {
+			if !bytes.Equal(content, plain) {
+				return result{err: fmt.Errorf("%s is an encrypted template: reifying it in place needs a recipient to seal it to", j.src)}
+			}
+			return result{out: raw}
+		}
+		return result{out: content, sensitive: true}
+	}
+	return result{}
+}
+
+// output returns the content and mode of the file j writes.
+func (e *engine) output(j job, r result) ([]byte, fs.FileMode, error) {
+	out := r.out
+	if r.sensitive && e.o.recipient != nil {
+		var err error
+		if out, err = crypt.Seal(e.o.recipient, out); err != nil {
+			return nil, 0, fmt.Errorf("failed to seal %s: %w", j.src, err)
+		}
+	}
+
+	perm := j.perm
+	if j.act == ActionDecrypt && perm == 0 {
+		// Decrypted files stay private
+		perm = 0600
+	}
+	if perm == 0 {
+		perm = 0644
+		if info, err := os.Stat(j.dst); err == nil {
+			perm = info.Mode()
+		}
+	}
+	return out, perm, nil
+}
+
+// apply writes the result r of a rendering or decrypting job j.
+func (e *engine) apply(j job, r result) error {
+	out, perm, err := e.output(j, r)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(j.dst), 0755); err != nil {
+		return fmt.Errorf("failed to create dest dir: %w", err)
+	}
+	if unchanged(j.dst, out, perm) {
+		return nil
+	}
+
+	// Write next to dst, so that it is replaced at once
+	tmp, err := os.CreateTemp(filepath.Dir(j.dst), "."+filepath.Base(j.dst)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(out); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), j.dst)
+}
+
+// stage adds the changes of j, with result r, to the transaction t, and
+// reports whether the target changes. A nil t only reports.
+func (e *engine) stage(t *transaction, j job, r result) (bool, error) {
+	switch j.act {
+	case ActionSkip:
+		return false, nil
+
+	case ActionRemove:
+		if t != nil {
+			t.remove(j.src)
+		}
+		return true, nil
+
+	case ActionLink:
+		return e.stageLink(t, j)
+
+	case ActionCopy:
+		if j.perm != 0 {
+			return e.stageCopy(t, j)
+		}
+		if j.src == j.dst {
+			return false, nil
+		}
+		if j.move {
+			if t != nil {
+				return true, t.move(j.src, j.dst)
+			}
+			return true, nil
+		}
+		if same, err := SameContent(j.src, j.dst); err == nil && same {
+			return false, nil
+		}
+		if t != nil {
+			return true, t.copy(j.src, j.dst, 0)
+		}
+		return true, nil
+	}
+
+	out, perm, err := e.output(j, r)
+	if err != nil {
+		return false, err
+	}
+	changed := !unchanged(j.dst, out, perm)
+	if t == nil {
+		return changed, nil
+	}
+	if changed || t.created[j.dst] || t.gone[j.dst] {
+		if err := t.write(j.dst, out, perm); err != nil {
+			return false, err
+		}
+	}
+	if j.src != j.dst && j.move {
+		// Drop the template so the tree only holds what would be installed
+		t.remove(j.src)
+	}
+	return changed, nil
+}
+
+// stageCopy stages the copy job j of a file with a mode of its own: the
+// file is copied with that mode, then, when moving, its source dropped.
+func (e *engine) stageCopy(t *transaction, j job) (bool, error) {
+	changed := !HasPerm(j.dst, j.perm)
+	if !changed && j.src != j.dst {
+		same, err := SameContent(j.src, j.dst)
+		changed = err != nil || !same
+	}
+	if t == nil {
+		return changed, nil
+	}
+	if changed || t.created[j.dst] || t.gone[j.dst] {
+		if err := t.copy(j.src, j.dst, j.perm); err != nil {
+			return false, err
+		}
+	}
+	if j.src != j.dst && j.move {
+		t.remove(j.src)
+	}
+	return changed, nil
+}
+
+// stageLink stages the link job j: dst becomes a link to where src points,
+// or, when moving, src itself.
+func (e *engine) stageLink(t *transaction, j job) (bool, error) {
+	if j.src == j.dst {
+		return false, nil
+	}
+	if j.move {
+		if t != nil {
+			return true, t.move(j.src, j.dst)
+		}
+		return true, nil
+	}
+	dest, err := os.Readlink(j.src)
+	if err != nil {
+		return false, err
+	}
+	if current, err := os.Readlink(j.dst); err == nil && current == dest {
+		return false, nil
+	}
+	if t != nil {
+		return true, t.symlink(dest, j.dst)
+	}
+	return true, nil
+}
+
+// unchanged reports whether path already holds content, with perm.
+func unchanged(path string, content []byte, perm fs.FileMode) bool {
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != perm.Perm() {
+		return false
+	}
+	current, err := os.ReadFile(path)
+	return err == nil && bytes.Equal(current, content)
+}
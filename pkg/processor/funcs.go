@@ -0,0 +1,323 @@
+package processor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/pelletier/go-toml/v2"
+	"go.yaml.in/yaml/v3"
+)
+
+// Funcs returns the function library available to every template, except
+// secret, include and the prompt functions, which only make sense while
+// rendering a file.
+func Funcs() template.FuncMap {
+	return builtinFuncs()
+}
+
+// builtinFuncs returns the function library available to every template.
+// Argument order follows the usual pipeline convention: the value being
+// transformed comes last, so `{{ .root.name | replace " " "-" | lower }}` works.
+//
+// Rendered files are cached: bump cacheVersion when a function changes what
+// it returns.
+func builtinFuncs() template.FuncMap {
+	return template.FuncMap{
+		// Strings
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"title":      title,
+		"trim":       strings.TrimSpace,
+		"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+		"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+		"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+		"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+		"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+		"repeat":     func(count int, s string) string { return strings.Repeat(s, count) },
+		"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+		"join":       join,
+		"quote":      func(v any) string { return fmt.Sprintf("%q", toString(v)) },
+		"squote":     func(v any) string { return "'" + toString(v) + "'" },
+		"indent":     indent,
+		"nindent":    func(n int, s string) string { return "\n" + indent(n, s) },
+
+		// Defaults and logic
+		"default":  defaultValue,
+		"coalesce": coalesce,
+		"ternary":  ternary,
+		"empty":    isEmpty,
+
+		// Lists and dicts
+		"list":   func(items ...any) []any { return items },
+		"dict":   dict,
+		"keys":   keys,
+		"hasKey": hasKey,
+		"get":    get,
+		"first":  first,
+		"last":   last,
+
+		// Encoding
+		"toJson":    toJSON,
+		"toToml":    toTOML,
+		"toYaml":    toYAML,
+		"b64enc":    func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+		"b64dec":    b64dec,
+		"sha256sum": func(s string) string { sum := sha256.Sum256([]byte(s)); return hex.EncodeToString(sum[:]) },
+
+		// Regular expressions
+		"regexMatch":      regexMatch,
+		"regexFind":       regexFind,
+		"regexFindAll":    regexFindAll,
+		"regexReplaceAll": regexReplaceAll,
+
+		// Paths (always slash separated, as in the repository)
+		"pathJoin": path.Join,
+		"pathBase": path.Base,
+		"pathDir":  path.Dir,
+		"pathExt":  path.Ext,
+	}
+}
+
+// title upper-cases the first letter of every word.
+func title(s string) string {
+	prev := ' '
+	return strings.Map(func(r rune) rune {
+		out := r
+		if unicode.IsSpace(prev) || prev == '-' || prev == '_' {
+			out = unicode.ToUpper(r)
+		}
+		prev = r
+		return out
+	}, s)
+}
+
+func toString(v any) string {
+	switch s := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	case fmt.Stringer:
+		return s.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func toSlice(v any) ([]any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, fmt.Errorf("expected a list, got %T", v)
+	}
+	out := make([]any, rv.Len())
+	for i := range out {
+		out[i] = rv.Index(i).Interface()
+	}
+	return out, nil
+}
+
+func join(sep string, v any) (string, error) {
+	items, err := toSlice(v)
+	if err != nil {
+		return "", err
+	}
+	parts := make([]string, len(items))
+	for i, item := range items {
+		parts[i] = toString(item)
+	}
+	return strings.Join(parts, sep), nil
+}
+
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
+
+// isEmpty reports whether v is the zero value for its type, treating empty
+// lists and maps as empty.
+func isEmpty(v any) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return rv.IsNil()
+	default:
+		return rv.IsZero()
+	}
+}
+
+func defaultValue(def any, v ...any) any {
+	if len(v) == 0 || isEmpty(v[0]) {
+		return def
+	}
+	return v[0]
+}
+
+func coalesce(v ...any) any {
+	for _, item := range v {
+		if !isEmpty(item) {
+			return item
+		}
+	}
+	return nil
+}
+
+func ternary(whenTrue, whenFalse any, cond bool) any {
+	if cond {
+		return whenTrue
+	}
+	return whenFalse
+}
+
+func dict(pairs ...any) (map[string]any, error) {
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("dict requires an even number of arguments")
+	}
+	out := make(map[string]any, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict keys must be strings, got %T", pairs[i])
+		}
+		out[key] = pairs[i+1]
+	}
+	return out, nil
+}
+
+func mapValue(m any) (reflect.Value, error) {
+	rv := reflect.ValueOf(m)
+	if rv.Kind() != reflect.Map || rv.Type().Key().Kind() != reflect.String {
+		return reflect.Value{}, fmt.Errorf("expected a map with string keys, got %T", m)
+	}
+	return rv, nil
+}
+
+func keys(m any) ([]string, error) {
+	rv, err := mapValue(m)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, 0, rv.Len())
+	for _, k := range rv.MapKeys() {
+		out = append(out, k.String())
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func hasKey(m any, key string) (bool, error) {
+	rv, err := mapValue(m)
+	if err != nil {
+		return false, err
+	}
+	return rv.MapIndex(reflect.ValueOf(key).Convert(rv.Type().Key())).IsValid(), nil
+}
+
+func get(m any, key string) (any, error) {
+	rv, err := mapValue(m)
+	if err != nil {
+		return nil, err
+	}
+	v := rv.MapIndex(reflect.ValueOf(key).Convert(rv.Type().Key()))
+	if !v.IsValid() {
+		return nil, nil
+	}
+	return v.Interface(), nil
+}
+
+func first(v any) (any, error) {
+	items, err := toSlice(v)
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+	return items[0], nil
+}
+
+func last(v any) (any, error) {
+	items, err := toSlice(v)
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+	return items[len(items)-1], nil
+}
+
+func toJSON(v any) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func toTOML(v any) (string, error) {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(v); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+func toYAML(v any) (string, error) {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(b), "\n"), nil
+}
+
+func b64dec(s string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func regexMatch(pattern, s string) (bool, error) {
+	return regexp.MatchString(pattern, s)
+}
+
+func regexFind(pattern, s string) (string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", err
+	}
+	return re.FindString(s), nil
+}
+
+func regexFindAll(pattern string, n int, s string) ([]string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return re.FindAllString(s, n), nil
+}
+
+func regexReplaceAll(pattern, repl, s string) (string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", err
+	}
+	return re.ReplaceAllString(s, repl), nil
+}
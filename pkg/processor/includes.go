@@ -0,0 +1,163 @@
+package processor
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// MetaDir is the repository directory reserved for scadufax itself.
+// Nothing under it is ever installed into home.
+const MetaDir = ".scadufax"
+
+// IncludesDir holds the shared templates, relative to the repository root.
+var IncludesDir = filepath.Join(MetaDir, "templates")
+
+// forksDir holds machine overrides, relative to IncludesDir.
+const forksDir = "forks"
+
+// overridePrefix names a copy of every template a machine override defines,
+// so it can be applied again over blocks a dotfile declares itself.
+const overridePrefix = "scadufax.override/"
+
+// LoadIncludes parses every file under dir as a named template, named after
+// its slash separated path relative to dir (e.g. "shell/aliases"). Files may
+// also {{ define }} or {{ block }} further templates.
+//
+// Files under dir/forks/<fork>/ are parsed last and override the shared
+// template of the same relative name, as well as any block they redefine,
+// even one a rendered file declares. Overrides for other forks are ignored.
+//
+// It returns nil if dir does not exist.
+func LoadIncludes(dir, fork string) (*template.Template, error) {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	set := template.New("").Funcs(parseFuncs()).Option("missingkey=error")
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if rel == forksDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		return parseInclude(set, filepath.ToSlash(rel), path)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Machine overrides are parsed last so they win.
+	forkDir := filepath.Join(dir, forksDir, fork)
+	if fork == "" {
+		return set, nil
+	}
+	if _, err := os.Stat(forkDir); os.IsNotExist(err) {
+		return set, nil
+	}
+	err = filepath.WalkDir(forkDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(forkDir, path)
+		if err != nil {
+			return err
+		}
+		return parseOverride(set, filepath.ToSlash(rel), path)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return set, nil
+}
+
+// parseOverride parses the machine override at path into set, keeping a
+// copy of every template it defines under overridePrefix. Empty templates,
+// such as a file holding only definitions, override nothing.
+func parseOverride(set *template.Template, name, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	file, err := template.New(name).Funcs(parseFuncs()).Parse(string(content))
+	if err != nil {
+		return fmt.Errorf("failed to parse include %s: %w", path, err)
+	}
+	for _, t := range file.Templates() {
+		if t.Tree == nil || parse.IsEmptyTree(t.Tree.Root) {
+			continue
+		}
+		if _, err := set.AddParseTree(t.Name(), t.Tree); err != nil {
+			return err
+		}
+		if _, err := set.AddParseTree(overridePrefix+t.Name(), t.Tree); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyOverrides defines again, in the namespace of tmpl, every template
+// the machine overrides, but tmpl itself: blocks tmpl declares do not
+// replace them.
+func applyOverrides(tmpl *template.Template) error {
+	for _, t := range tmpl.Templates() {
+		name, ok := strings.CutPrefix(t.Name(), overridePrefix)
+		if !ok || name == tmpl.Name() {
+			continue
+		}
+		if _, err := tmpl.AddParseTree(name, t.Tree); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseInclude(set *template.Template, name, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if _, err := set.New(name).Parse(string(content)); err != nil {
+		return fmt.Errorf("failed to parse include %s: %w", path, err)
+	}
+	return nil
+}
+
+// parseFuncs returns placeholders for every function a template may call, so
+// includes can be parsed before the real secret source is known.
+func parseFuncs() template.FuncMap {
+	funcMap := builtinFuncs()
+	funcMap["secret"] = func(string, ...string) (string, error) { return "", nil }
+	funcMap["include"] = func(string, any) (string, error) { return "", nil }
+	for name, fn := range promptFuncs(nil) {
+		funcMap[name] = fn
+	}
+	return funcMap
+}
+
+// includeFunc renders the named template of set into a string, so the result
+// can be piped, e.g. {{ include "aliases" . | indent 2 }}.
+func includeFunc(set *template.Template) func(string, any) (string, error) {
+	return func(name string, data any) (string, error) {
+		var buf strings.Builder
+		if err := set.ExecuteTemplate(&buf, name, data); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	}
+}
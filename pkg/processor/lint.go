@@ -0,0 +1,313 @@
+package processor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// Problem is an error found in a template by Lint.
+type Problem struct {
+	File string
+	Line int
+	// Col is 0 when only the line is known.
+	Col int
+	Msg string
+	// Snippet is the source line at fault.
+	Snippet string
+	// Suggestions are keys close to a misspelled one.
+	Suggestions []string
+}
+
+// String formats p as "file:line:col: message".
+func (p Problem) String() string {
+	pos := fmt.Sprintf("%s:%d", p.File, p.Line)
+	if p.Col > 0 {
+		pos += fmt.Sprintf(":%d", p.Col)
+	}
+	msg := p.Msg
+	if len(p.Suggestions) > 0 {
+		msg += fmt.Sprintf(" (did you mean %s?)", quoteAll(p.Suggestions))
+	}
+	return pos + ": " + msg
+}
+
+// Lint parses content as the template name and checks it against data.
+// Every key the template reads from data must exist; then it is rendered,
+// without looking up secrets, to catch any other error. All missing keys
+// are reported at once, with suggestions for misspelled ones.
+func Lint(name string, content []byte, data map[string]any, opts ...Option) []Problem {
+	return lint(name, content, data, newOptions(opts))
+}
+
+func lint(name string, content []byte, data map[string]any, o *options) []Problem {
+	header, body, ok := splitHeader(content)
+	if !ok {
+		return lintBody(name, content, delims{o.left, o.right}, data, o)
+	}
+
+	// Lines are reported as in the file, header included
+	problems := lintBody(name, body, header, data, o)
+	for i := range problems {
+		if problems[i].File == name {
+			problems[i].Line++
+		}
+	}
+	return problems
+}
+
+// lintBody lints content, parsed with the delimiters d.
+func lintBody(name string, content []byte, d delims, data map[string]any, o *options) []Problem {
+	tmpl, err := template.New(name).Funcs(o.parseFuncs()).Delims(d.left, d.right).Parse(string(content))
+	if err != nil {
+		return []Problem{problemFromError(name, content, err)}
+	}
+
+	// The main template runs with the data as dot; {{ define }}d ones
+	// with whatever they are given, so only the former is checked
+	var problems []Problem
+	checkKeys(tmpl.Tree.Root, true, data, func(node parse.Node, path []string, msg string, suggestions []string) {
+		line, col := position(content, keyOffset(content, node, path))
+		problems = append(problems, Problem{
+			File:        name,
+			Line:        line,
+			Col:         col,
+			Msg:         msg,
+			Snippet:     sourceLine(content, line),
+			Suggestions: suggestions,
+		})
+	})
+	if len(problems) > 0 {
+		return problems
+	}
+
+	noSecrets := func(string, ...string) (string, error) { return "", nil }
+	e, err := newEngine(data, noSecrets, o)
+	if err != nil {
+		return []Problem{{File: name, Line: 1, Msg: err.Error()}}
+	}
+	if _, _, err := e.render(name, content, d); err != nil {
+		// Report the template's own error, not our wrapping of it
+		for inner := errors.Unwrap(err); inner != nil; inner = errors.Unwrap(inner) {
+			err = inner
+		}
+		return []Problem{problemFromError(name, content, err)}
+	}
+	return nil
+}
+
+// keyReporter is called for a node reading a key that does not exist: the
+// last element of path.
+type keyReporter func(node parse.Node, path []string, msg string, suggestions []string)
+
+// checkKeys reports every field of the data that node reads and that does
+// not exist. root tells whether dot is the data itself.
+func checkKeys(node parse.Node, root bool, data map[string]any, report keyReporter) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			checkKeys(child, root, data, report)
+		}
+	case *parse.ActionNode:
+		checkKeys(n.Pipe, root, data, report)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			checkKeys(cmd, root, data, report)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			checkKeys(arg, root, data, report)
+		}
+	case *parse.IfNode:
+		checkKeys(n.Pipe, root, data, report)
+		checkKeys(n.List, root, data, report)
+		checkKeys(n.ElseList, root, data, report)
+	case *parse.RangeNode:
+		// Dot is each element inside the loop
+		checkKeys(n.Pipe, root, data, report)
+		checkKeys(n.List, false, data, report)
+		checkKeys(n.ElseList, root, data, report)
+	case *parse.WithNode:
+		checkKeys(n.Pipe, root, data, report)
+		checkKeys(n.List, false, data, report)
+		checkKeys(n.ElseList, root, data, report)
+	case *parse.TemplateNode:
+		checkKeys(n.Pipe, root, data, report)
+	case *parse.FieldNode:
+		if root {
+			lookupPath(n, n.Ident, data, report)
+		}
+	case *parse.VariableNode:
+		// $ is always the data in the main template
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			lookupPath(n, n.Ident[1:], data, report)
+		}
+	}
+}
+
+// lookupPath reports the first key of path missing from data.
+func lookupPath(node parse.Node, path []string, data map[string]any, report keyReporter) {
+	var cur any = data
+	for i, key := range path {
+		rv := reflect.ValueOf(cur)
+		if rv.Kind() != reflect.Map || rv.Type().Key().Kind() != reflect.String {
+			if i > 0 {
+				report(node, path[:i], fmt.Sprintf(".%s is not a table", strings.Join(path[:i], ".")), nil)
+			}
+			return
+		}
+		v := rv.MapIndex(reflect.ValueOf(key).Convert(rv.Type().Key()))
+		if !v.IsValid() {
+			var candidates []string
+			for _, k := range rv.MapKeys() {
+				candidates = append(candidates, k.String())
+			}
+			where := "the data"
+			if i > 0 {
+				where = "." + strings.Join(path[:i], ".")
+			}
+			report(node, path[:i+1], fmt.Sprintf("no key %q in %s", key, where), suggest(key, candidates))
+			return
+		}
+		cur = v.Interface()
+	}
+}
+
+// keyOffset returns the offset in content of the last key of path, read by
+// node. The parser places nodes reading several keys at their last one.
+func keyOffset(content []byte, node parse.Node, path []string) int {
+	text := node.String()
+	end := min(int(node.Position())+len(text), len(content))
+	start := bytes.LastIndex(content[:end], []byte(text))
+	if start < 0 {
+		return int(node.Position())
+	}
+	// text ends with path and whatever keys follow it
+	var all []string
+	switch n := node.(type) {
+	case *parse.FieldNode:
+		all = n.Ident
+	case *parse.VariableNode:
+		all = n.Ident[1:]
+	}
+	rest := strings.Join(all[len(path)-1:], ".")
+	return start + len(text) - len(rest)
+}
+
+// templateErrRe matches the position text/template puts in its errors:
+// "template: name:line: ..." or "template: name:line:col: ...".
+var templateErrRe = regexp.MustCompile(`^template: (.+?):(\d+):(?:(\d+):)? (.*)$`)
+
+func problemFromError(name string, content []byte, err error) Problem {
+	p := Problem{File: name, Line: 1, Msg: err.Error()}
+	m := templateErrRe.FindStringSubmatch(err.Error())
+	if m == nil {
+		return p
+	}
+	p.File = m[1]
+	if p.File == path.Base(name) {
+		// Templates are named after the file alone when rendered
+		p.File = name
+	}
+	p.Line, _ = strconv.Atoi(m[2])
+	p.Col, _ = strconv.Atoi(m[3])
+	p.Msg = m[4]
+	if p.File == name {
+		p.Snippet = sourceLine(content, p.Line)
+	}
+	return p
+}
+
+// position converts a byte offset into content to a 1-based line and column.
+func position(content []byte, offset int) (int, int) {
+	if offset > len(content) {
+		offset = len(content)
+	}
+	before := content[:offset]
+	line := 1 + bytes.Count(before, []byte("\n"))
+	col := offset - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
+
+func sourceLine(content []byte, line int) string {
+	lines := strings.Split(string(content), "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+	return strings.TrimRight(lines[line-1], "\r")
+}
+
+// suggest returns the candidates within a small edit distance of word,
+// closest first.
+func suggest(word string, candidates []string) []string {
+	limit := 2
+	if len(word) <= 3 {
+		limit = 1
+	}
+
+	type match struct {
+		key  string
+		dist int
+	}
+	var matches []match
+	for _, c := range candidates {
+		if d := levenshtein(strings.ToLower(word), strings.ToLower(c)); d <= limit {
+			matches = append(matches, match{c, d})
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].dist != matches[j].dist {
+			return matches[i].dist < matches[j].dist
+		}
+		return matches[i].key < matches[j].key
+	})
+
+	out := make([]string, len(matches))
+	for i, m := range matches {
+		out[i] = m.key
+	}
+	return out
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func quoteAll(items []string) string {
+	quoted := make([]string, len(items))
+	for i, item := range items {
+		quoted[i] = strconv.Quote(item)
+	}
+	return strings.Join(quoted, " or ")
+}
@@ -0,0 +1,19 @@
+package processor
+
+import "strings"
+
+// IsTemplate reports whether the repository file at rel should be rendered.
+// With an empty suffix every file is a template; otherwise only files ending
+// in suffix are, and everything else is copied verbatim.
+func IsTemplate(rel, suffix string) bool {
+	return suffix == "" || strings.HasSuffix(rel, suffix)
+}
+
+// TargetName returns the name a repository file is installed under: the
+// template suffix, if any, is stripped.
+func TargetName(rel, suffix string) string {
+	if suffix == "" || rel == suffix {
+		return rel
+	}
+	return strings.TrimSuffix(rel, suffix)
+}
@@ -0,0 +1,56 @@
+package processor
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// WithPerms makes Reify and ReifyTree ask fn for the mode of each file, by
+// target name (see Filter). Files it has no mode for keep the mode of their
+// source, or 0600 when decrypted.
+func WithPerms(fn func(target string) (fs.FileMode, bool)) Option {
+	return func(o *options) {
+		o.perms = fn
+	}
+}
+
+// WithDirs makes Reify and ReifyTree create the directories in dirs, by
+// slash separated name relative to the destination, with their modes, even
+// when nothing is reified into them. Existing ones get their mode fixed.
+func WithDirs(dirs map[string]fs.FileMode) Option {
+	return func(o *options) {
+		o.dirs = dirs
+	}
+}
+
+// ensureDirs creates the directories dirs under root with their modes,
+// parents first.
+func ensureDirs(root string, dirs map[string]fs.FileMode) error {
+	names := make([]string, 0, len(dirs))
+	for name := range dirs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(path, dirs[name]); err != nil {
+			return fmt.Errorf("failed to create %s: %w", path, err)
+		}
+		if HasPerm(path, dirs[name]) {
+			continue
+		}
+		if err := os.Chmod(path, dirs[name]); err != nil {
+			return fmt.Errorf("failed to set mode of %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// HasPerm reports whether path exists with the permission bits of perm.
+func HasPerm(path string, perm fs.FileMode) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().Perm() == perm.Perm()
+}
@@ -1,166 +1,254 @@
 package processor
 
 import (
-	"bytes"
 	"fmt"
-	"io"
 	"io/fs"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"runtime"
 	"text/template"
 
 	"github.com/joho/godotenv"
+	"github.com/suderio/scadufax/pkg/crypt"
 )
 
-// Reify walks the root directory and applies the template to each file.
-// If dryRun is true, it only checks for errors and does not write to files.
-func Reify(root string, data map[string]any, secretFn func(string) (string, error), dryRun bool) error {
-	// First pass: Dry-run/Validation
-	err := walkAndProcess(root, data, secretFn, true)
-	if err != nil {
-		return fmt.Errorf("dry-run failed: %w", err)
-	}
+// SecretFunc backs the `secret` template function. Templates call it as
+// {{ "KEY" | secret }} or {{ secret "KEY" "backend" }}; see package secrets.
+type SecretFunc func(key string, backend ...string) (string, error)
 
-	if dryRun {
+// KeySecretFunc adapts fn, which looks secrets up by key alone, to a
+// SecretFunc. Templates naming a backend fail with it.
+func KeySecretFunc(fn func(string) (string, error)) SecretFunc {
+	if fn == nil {
 		return nil
 	}
+	return func(key string, backend ...string) (string, error) {
+		if len(backend) > 0 {
+			return "", fmt.Errorf("secret %q: backend %q is not available", key, backend[0])
+		}
+		return fn(key)
+	}
+}
+
+// Filter reports whether the file at rel, slash separated and relative to
+// the tree root, is to be reified. rel is the file's target name, without
+// the template suffix.
+type Filter func(rel string) (bool, error)
+
+// Option customises how templates are rendered.
+type Option func(*options)
 
-	// Second pass: Execution
-	return walkAndProcess(root, data, secretFn, false)
+type options struct {
+	includes  *template.Template
+	suffix    string
+	key       []byte
+	recipient *[32]byte
+	filter    Filter
+	ignores   []string
+	machine   *Machine
+	workers   int
+	cacheDir  string
+
+	left, right string
+	fileDelims  func(target string) (left, right string)
+
+	perms func(target string) (fs.FileMode, bool)
+	dirs  map[string]fs.FileMode
+
+	// Set by NewRenderer
+	funcs   template.FuncMap
+	lenient bool
+	logger  *slog.Logger
 }
 
-// ReifyFile processes a single file from sourcePath and writes it to destPath.
-func ReifyFile(sourcePath, destPath string, data map[string]any, secretFn func(string) (string, error)) error {
-	content, err := os.ReadFile(sourcePath)
-	if err != nil {
-		return err
+// WithIncludes makes the named templates in set (see LoadIncludes) available
+// to every rendered file.
+func WithIncludes(set *template.Template) Option {
+	return func(o *options) {
+		o.includes = set
 	}
+}
 
-	// Make sure dest directory exists
-	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
-		return fmt.Errorf("failed to create dest dir: %w", err)
+// WithTemplateSuffix enables opt-in templating: only files ending in suffix
+// are rendered, and have the suffix stripped from their output name. All
+// other files are left (or copied) byte-for-byte.
+func WithTemplateSuffix(suffix string) Option {
+	return func(o *options) {
+		o.suffix = suffix
 	}
-
-	return renderAndWrite(sourcePath, destPath, content, data, secretFn, false)
 }
 
-func walkAndProcess(root string, data map[string]any, secretFn func(string) (string, error), dryRun bool) error {
-	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if d.IsDir() {
-			if d.Name() == ".git" {
-				return filepath.SkipDir
-			}
-			return nil
-		}
-
-		// Process file: source and dest are the same for recursive reify
-		return processFile(path, data, secretFn, dryRun)
-	})
+// WithKey sets the symmetric key (see package crypt) used to decrypt
+// encrypted files before rendering them. Without it, ReifyFile fails on
+// encrypted files and Reify leaves them untouched.
+func WithKey(key []byte) Option {
+	return func(o *options) {
+		o.key = key
+	}
 }
 
-func processFile(path string, data map[string]any, secretFn func(string) (string, error), dryRun bool) error {
-	content, err := os.ReadFile(path)
-	if err != nil {
-		return err
+// WithRecipient seals sensitive output to a machine's public key (see
+// crypt.Seal): every file that called the secret function, and every
+// encrypted source file. Only that machine can then read them.
+func WithRecipient(pub *[32]byte) Option {
+	return func(o *options) {
+		o.recipient = pub
 	}
+}
 
-	return renderAndWrite(path, path, content, data, secretFn, dryRun)
+// WithFilter makes Reify skip the files filter rejects: they are neither
+// rendered nor renamed.
+func WithFilter(filter Filter) Option {
+	return func(o *options) {
+		o.filter = filter
+	}
 }
 
-func renderAndWrite(name, destPath string, content []byte, data map[string]any, secretFn func(string) (string, error), dryRun bool) error {
-	// Define FuncMap
-	funcMap := template.FuncMap{
-		"secret": secretFn,
+// WithIgnore makes Reify and ReifyTree skip the files whose target name,
+// relative to the tree root, matches one of patterns (see filepath.Match).
+func WithIgnore(patterns ...string) Option {
+	return func(o *options) {
+		o.ignores = append(o.ignores, patterns...)
 	}
+}
 
-	// Parse template
-	tmpl, err := template.New(filepath.Base(name)).Funcs(funcMap).Option("missingkey=error").Parse(string(content))
-	if err != nil {
-		return fmt.Errorf("failed to parse template %s: %w", name, err)
+// WithMachine makes Reify resolve alternates (see AltSep) for m: the
+// selected variant of every file is reified under its base name, and the
+// other variants are removed.
+func WithMachine(m Machine) Option {
+	return func(o *options) {
+		o.machine = &m
 	}
+}
 
-	var buf bytes.Buffer
-	if dryRun {
-		if err := tmpl.Execute(io.Discard, data); err != nil {
-			return fmt.Errorf("failed to execute template %s: %w", name, err)
-		}
-		return nil
+// WithWorkers sets how many files are rendered at once. It defaults to the
+// number of CPUs.
+func WithWorkers(n int) Option {
+	return func(o *options) {
+		o.workers = n
 	}
+}
 
-	if err := tmpl.Execute(&buf, data); err != nil {
-		return fmt.Errorf("failed to execute template %s: %w", name, err)
+// WithCache keeps rendered files in dir, so a file is only rendered again
+// when its source, the data, the includes or a secret it reads change. The
+// cache holds rendered secrets: dir must not be shared. It is not used with
+// custom functions, whose results it cannot tell apart.
+func WithCache(dir string) Option {
+	return func(o *options) {
+		o.cacheDir = dir
 	}
+}
 
-	// Overwrite file
-	perm := os.FileMode(0644)
-	if info, err := os.Stat(destPath); err == nil {
-		perm = info.Mode()
+// missingKey is the template option for keys missing from the data.
+func (o *options) missingKey() string {
+	if o.lenient {
+		return "missingkey=default"
 	}
+	return "missingkey=error"
+}
 
-	return os.WriteFile(destPath, buf.Bytes(), perm)
+// parseFuncs returns the functions templates may call, for parsing.
+func (o *options) parseFuncs() template.FuncMap {
+	funcs := parseFuncs()
+	for name, fn := range o.funcs {
+		funcs[name] = fn
+	}
+	return funcs
 }
 
-// Helper to load env
-func LoadEnv(dir string) (map[string]string, error) {
-	return godotenv.Read(filepath.Join(dir, ".env"))
+func newOptions(opts []Option) *options {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.workers < 1 {
+		o.workers = runtime.NumCPU()
+	}
+	if o.logger == nil {
+		o.logger = slog.New(slog.DiscardHandler)
+	}
+	return o
 }
 
-// GetSecretFn returns a function that retrieves secrets from the .env file at envPath.
-// If required is true, missing .env or missing keys will return an error.
-// If required is false, missing .env is ignored (empty secrets), but missing keys still error if called?
-// Wait, original logic:
-// Reify: if --secret is false, return {{ "key" | secret }} literal.
-// Reify: if --secret is true, load .env. If .env fails, error. If key missing, error.
-// Edit: always loads .env (if present?). If key missing, error.
-// The "required" param here might map to "useSecret" flag in reify?
-// Actually reify logic is: if !useSecret, return formatted string.
-// So GetSecretFn should probably handle the extraction of values from the map only.
-// Consolidating:
-// 1. Load .env (handled by caller or helper?). Reify loads from targetDir, Edit from homeDir.
-// 2. Closure to lookup key.
-// Let's make GetSecretFn take the envMap or the envPath?
-// Reify: "if --secret passed... read .env at targetPath".
-// Edit: "read .env at homeDir".
-// So passing envPath seems correct.
+// Reify walks the root directory and applies the template to each file.
+// If dryRun is true, it only checks for errors and does not write to files.
+// The MetaDir directory is never rendered. Every file is rendered, in
+// parallel, before anything is written, so a failing template leaves the
+// tree untouched. The changes are then applied as a transaction (see
+// TxnDir): on failure, or after a crash, the tree is rolled back.
+// secretFn looks secrets up by key, as KeySecretFunc; to use backends, see
+// ReifyTree or Renderer.
+func Reify(root string, data map[string]any, secretFn func(string) (string, error), dryRun bool, opts ...Option) error {
+	e, err := newEngine(data, KeySecretFunc(secretFn), newOptions(opts))
+	if err != nil {
+		return err
+	}
+	_, err = e.reifyTree(root, root, dryRun)
+	return err
+}
 
-func GetSecretFn(envPath string, required bool) (func(string) (string, error), error) {
-	var envMap map[string]string
-	var err error
+// ReifyFile processes a single file from sourcePath and writes it to destPath.
+// Binary files, and files without the template suffix when one is
+// configured, are streamed to destPath verbatim. Encrypted files are
+// decrypted first and written with 0600 permissions. secretFn is used as
+// in Reify; Renderer.RenderFile takes a SecretFunc.
+func ReifyFile(sourcePath, destPath string, data map[string]any, secretFn func(string) (string, error), opts ...Option) error {
+	e, err := newEngine(data, KeySecretFunc(secretFn), newOptions(opts))
+	if err != nil {
+		return err
+	}
+	return e.reifyFile(sourcePath, destPath)
+}
 
-	if required {
-		envMap, err = godotenv.Read(envPath)
+func (e *engine) reifyFile(sourcePath, destPath string) error {
+	j := job{src: sourcePath, dst: destPath}
+	encrypted, err := crypt.IsEncryptedFile(sourcePath)
+	if err != nil {
+		return err
+	}
+	if encrypted {
+		j.act = ActionDecrypt
+	} else {
+		binary, err := IsBinary(sourcePath)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read .env at %s: %w", envPath, err)
+			return err
 		}
-	} else {
-		// If not required, try to read but ignore not exists
-		envMap, err = godotenv.Read(envPath)
-		if err != nil && !os.IsNotExist(err) {
-			return nil, fmt.Errorf("failed to read .env at %s: %w", envPath, err)
+		if binary || !IsTemplate(sourcePath, e.o.suffix) {
+			return CopyFile(sourcePath, destPath)
 		}
+		j.act = ActionRender
+	}
+
+	r := e.prepare(j)
+	if r.err != nil {
+		return r.err
+	}
+	return e.apply(j, r)
+}
+
+// LoadEnv reads the .env file in dir.
+//
+// Deprecated: use secrets.NewDotenv, or secrets.Build for every backend.
+func LoadEnv(dir string) (map[string]string, error) {
+	return godotenv.Read(filepath.Join(dir, ".env"))
+}
+
+// GetSecretFn returns a function that looks secrets up in the .env file at
+// envPath. If required is true, a missing .env is an error; otherwise it
+// holds no secrets. A missing key is always an error.
+//
+// Deprecated: use the Lookup method of secrets.NewDotenv, which is a
+// SecretFunc once wrapped with KeySecretFunc.
+func GetSecretFn(envPath string, required bool) (func(string) (string, error), error) {
+	envMap, err := godotenv.Read(envPath)
+	if err != nil && (required || !os.IsNotExist(err)) {
+		return nil, fmt.Errorf("failed to read .env at %s: %w", envPath, err)
 	}
 
 	return func(key string) (string, error) {
-		// If map is nil (file didn't exist and not required), or key missing:
 		val, ok := envMap[key]
 		if !ok {
-			if required {
-				return "", fmt.Errorf("secret key %q not found in .env at %s", key, envPath)
-			}
-			// If not required (e.g. edit command might allow partial validation?)
-			// But wait, Reify command if !useSecret returns the literal template string.
-			// That logic is specific to "generating" vs "reverting" or "dry-run"?
-			// No, reify without secret flag generates files WITH the secret tag preserved.
-			// This logic seems specific to reify command's flag.
-			// The requested refactor is to unify "how secrets are retrieved".
-			// Let's assume GetSecretFn returns the lookup function.
-			// Reify logic for !useSecret should stay in Reify command?
-			// The user said: "The secretFn function is actually a placeholder... Can you refactor so that we use the same version in the reify and the edit?"
-
-			// So let's implement the "Look up key in loaded .env" part as the shared logic.
 			return "", fmt.Errorf("secret key %q not found in .env at %s", key, envPath)
 		}
 		return val, nil
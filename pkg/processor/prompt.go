@@ -0,0 +1,297 @@
+package processor
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// PromptKind is the kind of answer a Prompt expects.
+type PromptKind int
+
+const (
+	// PromptString expects any text.
+	PromptString PromptKind = iota
+	// PromptBool expects yes or no.
+	PromptBool
+	// PromptChoice expects one of Choices.
+	PromptChoice
+)
+
+func (k PromptKind) String() string {
+	switch k {
+	case PromptString:
+		return "string"
+	case PromptBool:
+		return "bool"
+	case PromptChoice:
+		return "choice"
+	}
+	return fmt.Sprintf("PromptKind(%d)", int(k))
+}
+
+// Prompt is a value templates read that is missing from the data, to be
+// asked for before rendering them.
+type Prompt struct {
+	// Key is the dotted path of the value in the data, e.g. "root.email".
+	Key  string
+	Kind PromptKind
+	// Text is the question, or Key when the value is read without a prompt
+	// function.
+	Text string
+	// Default is the suggested answer, if any.
+	Default string
+	Choices []string
+}
+
+// promptFuncs returns the prompt functions, reading their answers from data.
+//
+//	{{ promptString "root.email" "Your email" }}
+//	{{ promptBool "root.work" "Is this a work machine" false }}
+//	{{ promptChoice "root.shell" "Login shell" (list "bash" "zsh") "zsh" }}
+//
+// Each returns the value at the key, or the default when the key is missing;
+// without a default, a missing key is an error. FindPrompts lists the keys
+// to ask for before rendering.
+func promptFuncs(data map[string]any) template.FuncMap {
+	lookup := func(key string) (any, bool) {
+		return lookupKey(data, key)
+	}
+	return template.FuncMap{
+		"promptString": func(key, text string, def ...string) (string, error) {
+			if value, ok := lookup(key); ok {
+				return toString(value), nil
+			}
+			if len(def) > 0 {
+				return def[0], nil
+			}
+			return "", fmt.Errorf("%s is not set", key)
+		},
+		"promptBool": func(key, text string, def ...bool) (bool, error) {
+			if value, ok := lookup(key); ok {
+				if b, isBool := value.(bool); isBool {
+					return b, nil
+				}
+				b, err := strconv.ParseBool(toString(value))
+				if err != nil {
+					return false, fmt.Errorf("%s is not a boolean: %q", key, toString(value))
+				}
+				return b, nil
+			}
+			if len(def) > 0 {
+				return def[0], nil
+			}
+			return false, fmt.Errorf("%s is not set", key)
+		},
+		"promptChoice": func(key, text string, choices any, def ...string) (string, error) {
+			items, err := toSlice(choices)
+			if err != nil {
+				return "", err
+			}
+			value, ok := lookup(key)
+			if !ok {
+				if len(def) == 0 {
+					return "", fmt.Errorf("%s is not set", key)
+				}
+				value = def[0]
+			}
+			answer := toString(value)
+			for _, item := range items {
+				if toString(item) == answer {
+					return answer, nil
+				}
+			}
+			return "", fmt.Errorf("%s is %q, not one of %s", key, answer, quoteAll(stringsOf(items)))
+		},
+	}
+}
+
+// lookupKey returns the value at the dotted path key of data.
+func lookupKey(data map[string]any, key string) (any, bool) {
+	var cur any = data
+	for _, k := range strings.Split(key, ".") {
+		rv := reflect.ValueOf(cur)
+		if rv.Kind() != reflect.Map || rv.Type().Key().Kind() != reflect.String {
+			return nil, false
+		}
+		v := rv.MapIndex(reflect.ValueOf(k).Convert(rv.Type().Key()))
+		if !v.IsValid() {
+			return nil, false
+		}
+		cur = v.Interface()
+	}
+	return cur, true
+}
+
+func stringsOf(items []any) []string {
+	out := make([]string, len(items))
+	for i, item := range items {
+		out[i] = toString(item)
+	}
+	return out
+}
+
+// FindPrompts lists the values the templates under root read and data
+// lacks, in walk order, so they can be asked for before reifying it. Keys
+// read through a prompt function come with its question; keys read
+// directly, e.g. {{ .root.email }}, are asked for as strings. Files are
+// selected as Reify selects them, with the same options.
+func FindPrompts(root string, data map[string]any, opts ...Option) ([]Prompt, error) {
+	return findPrompts(root, data, newOptions(opts))
+}
+
+func findPrompts(root string, data map[string]any, o *options) ([]Prompt, error) {
+	jobs, err := planTree(root, root, o)
+	if err != nil {
+		return nil, err
+	}
+
+	var prompts []Prompt
+	seen := map[string]int{}
+	add := func(p Prompt, explicit bool) {
+		if i, ok := seen[p.Key]; ok {
+			// A prompt function says more than a bare key
+			if explicit && prompts[i].Text == prompts[i].Key {
+				prompts[i] = p
+			}
+			return
+		}
+		seen[p.Key] = len(prompts)
+		prompts = append(prompts, p)
+	}
+
+	for _, j := range jobs {
+		if j.act != ActionRender {
+			continue
+		}
+		content, err := os.ReadFile(j.src)
+		if err != nil {
+			return nil, err
+		}
+		d := j.delims
+		if header, body, ok := splitHeader(content); ok {
+			d, content = header, body
+		}
+		if d.left == "" && d.right == "" {
+			d = delims{o.left, o.right}
+		}
+		tmpl, err := template.New(j.rel).Funcs(o.parseFuncs()).Delims(d.left, d.right).Parse(string(content))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse template %s: %w", j.rel, err)
+		}
+
+		for _, t := range tmpl.Templates() {
+			if t.Tree == nil {
+				continue
+			}
+			walkNodes(t.Tree.Root, func(node parse.Node) {
+				if pipe, ok := node.(*parse.PipeNode); ok {
+					for _, p := range promptCalls(pipe) {
+						if _, ok := lookupKey(data, p.Key); !ok {
+							add(p, true)
+						}
+					}
+				}
+			})
+		}
+		checkKeys(tmpl.Tree.Root, true, data, func(node parse.Node, path []string, _ string, _ []string) {
+			var full []string
+			switch n := node.(type) {
+			case *parse.FieldNode:
+				full = n.Ident
+			case *parse.VariableNode:
+				full = n.Ident[1:]
+			}
+			// A key under a value that is not a table cannot be set
+			if _, exists := lookupKey(data, strings.Join(path, ".")); exists {
+				return
+			}
+			key := strings.Join(full, ".")
+			add(Prompt{Key: key, Kind: PromptString, Text: key}, false)
+		})
+	}
+	return prompts, nil
+}
+
+// promptCalls returns the calls to prompt functions in pipe whose key and
+// question are literals.
+func promptCalls(pipe *parse.PipeNode) []Prompt {
+	var prompts []Prompt
+	for i, cmd := range pipe.Cmds {
+		ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+		if !ok {
+			continue
+		}
+		kind := PromptString
+		switch ident.Ident {
+		case "promptString":
+		case "promptBool":
+			kind = PromptBool
+		case "promptChoice":
+			kind = PromptChoice
+		default:
+			continue
+		}
+		args := cmd.Args[1:]
+		if i > 0 && len(pipe.Cmds[i-1].Args) == 1 {
+			// Piped value becomes the last argument
+			args = append(args[:len(args):len(args)], pipe.Cmds[i-1].Args[0])
+		}
+		if len(args) < 2 {
+			continue
+		}
+		p := Prompt{Key: stringLiteral(args[0]), Kind: kind, Text: stringLiteral(args[1])}
+		if p.Key == "" || p.Text == "" {
+			continue
+		}
+		rest := args[2:]
+		if kind == PromptChoice {
+			if len(rest) == 0 {
+				continue
+			}
+			p.Choices = listLiteral(rest[0])
+			if p.Choices == nil {
+				continue
+			}
+			rest = rest[1:]
+		}
+		if len(rest) > 0 {
+			p.Default = literal(rest[0])
+		}
+		prompts = append(prompts, p)
+	}
+	return prompts
+}
+
+// literal returns the text of a string or boolean literal.
+func literal(node parse.Node) string {
+	if b, ok := node.(*parse.BoolNode); ok {
+		return strconv.FormatBool(b.True)
+	}
+	return stringLiteral(node)
+}
+
+// listLiteral returns the items of a (list "a" "b") literal, or nil.
+func listLiteral(node parse.Node) []string {
+	pipe, ok := node.(*parse.PipeNode)
+	if !ok || len(pipe.Cmds) != 1 {
+		return nil
+	}
+	args := pipe.Cmds[0].Args
+	if ident, ok := args[0].(*parse.IdentifierNode); !ok || ident.Ident != "list" {
+		return nil
+	}
+	items := make([]string, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		s, ok := arg.(*parse.StringNode)
+		if !ok {
+			return nil
+		}
+		items = append(items, s.Text)
+	}
+	return items
+}
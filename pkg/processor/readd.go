@@ -0,0 +1,305 @@
+package processor
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// Conflict markers around the lines ReAdd cannot merge.
+const (
+	ConflictStart = "<<<<<<< template"
+	ConflictSep   = "======="
+	ConflictEnd   = ">>>>>>> home"
+)
+
+// ReAdd carries the changes that turned rendered, the output of tmpl, into
+// edited back to tmpl. Changes to lines copied verbatim from the template
+// are applied to it; changes touching lines a template action produced,
+// or next to lines it removed, are left between conflict markers, the
+// template's lines first, for the user to resolve. left and right are the
+// template's delimiters, empty for the default ones; a header (see
+// WithFileDelims) overrides them.
+//
+// It returns the new template and the number of conflicts.
+func ReAdd(tmpl, rendered, edited []byte, left, right string) ([]byte, int) {
+	// Changes never land above a header, which is not part of the output
+	start := 0
+	if header, _, ok := splitHeader(tmpl); ok {
+		left, right = header.left, header.right
+		start = 1
+	}
+	if left == "" {
+		left, right = "{{", "}}"
+	}
+
+	tLines := splitLines(tmpl)
+	rLines := splitLines(rendered)
+	eLines := splitLines(edited)
+
+	// Rendered lines copied verbatim from the template, by template line
+	origin := make([]int, len(rLines))
+	for i := range origin {
+		origin[i] = -1
+	}
+	actions := actionLines(tmpl, left, right)
+	for _, m := range alignLines(tmpl, rendered) {
+		if !actions[m.a] {
+			origin[m.b] = m.a
+		}
+	}
+	literal := func(r int) bool { return origin[r] >= 0 }
+
+	// Template line range [t0, t1) standing for rendered lines [r0, r1),
+	// if the correspondence is exact: the lines come from the template as
+	// is and in order, or, for an insertion, both neighbours agree on where
+	// it goes
+	templateRange := func(r0, r1 int) (int, int, bool) {
+		if r0 < r1 {
+			for r := r0; r < r1; r++ {
+				if !literal(r) || origin[r] != origin[r0]+(r-r0) {
+					return 0, 0, false
+				}
+			}
+			return origin[r0], origin[r1-1] + 1, true
+		}
+		at := -1
+		if r0 > 0 {
+			if !literal(r0 - 1) {
+				return 0, 0, false
+			}
+			at = origin[r0-1] + 1
+		}
+		if r0 < len(rLines) {
+			if !literal(r0) || (at >= 0 && origin[r0] != at) {
+				return 0, 0, false
+			}
+			at = origin[r0]
+		}
+		if at < 0 {
+			at = start
+		}
+		return at, at, true
+	}
+
+	type edit struct {
+		t0, t1 int
+		lines  []string
+	}
+	var edits []edit
+	conflicts := 0
+
+	hunks := diffHunks(rendered, edited)
+	for i := 0; i < len(hunks); i++ {
+		h := hunks[i]
+		if t0, t1, ok := templateRange(h.a0, h.a1); ok {
+			edits = append(edits, edit{t0, t1, eLines[h.b0:h.b1]})
+			continue
+		}
+
+		// Widen to the closest lines that come from the template as is,
+		// taking in the hunks that overlap
+		r0, r1, e0, e1 := h.a0, h.a1, h.b0, h.b1
+		for {
+			for r0 > 0 && !literal(r0-1) {
+				r0--
+				e0--
+			}
+			for r1 < len(rLines) && !literal(r1) {
+				r1++
+				e1++
+			}
+			if i+1 < len(hunks) && hunks[i+1].a0 < r1 {
+				i++
+				next := hunks[i]
+				// Lines between the two hunks are unchanged
+				e1 = next.b1 + (r1 - next.a1)
+				r1 = max(r1, next.a1)
+				e1 = max(e1, next.b1)
+				continue
+			}
+			break
+		}
+		t0, t1 := start, len(tLines)
+		if r0 > 0 {
+			t0 = origin[r0-1] + 1
+		}
+		if r1 < len(rLines) {
+			t1 = origin[r1]
+		}
+
+		block := []string{ConflictStart + "\n"}
+		block = append(block, terminated(tLines[t0:t1])...)
+		block = append(block, ConflictSep+"\n")
+		block = append(block, terminated(eLines[e0:e1])...)
+		block = append(block, ConflictEnd+"\n")
+		edits = append(edits, edit{t0, t1, block})
+		conflicts++
+	}
+
+	var out bytes.Buffer
+	write := func(line string) {
+		// Only the last line may go without a newline
+		if out.Len() > 0 && !bytes.HasSuffix(out.Bytes(), []byte("\n")) {
+			out.WriteByte('\n')
+		}
+		out.WriteString(line)
+	}
+	t := 0
+	for _, e := range edits {
+		for ; t < e.t0; t++ {
+			write(tLines[t])
+		}
+		for _, line := range e.lines {
+			write(line)
+		}
+		t = max(t, e.t1)
+	}
+	for ; t < len(tLines); t++ {
+		write(tLines[t])
+	}
+	return out.Bytes(), conflicts
+}
+
+// splitLines splits content after each newline.
+func splitLines(content []byte) []string {
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// terminated returns lines, the last one ending in a newline.
+func terminated(lines []string) []string {
+	if len(lines) == 0 || strings.HasSuffix(lines[len(lines)-1], "\n") {
+		return lines
+	}
+	out := append([]string(nil), lines...)
+	out[len(out)-1] += "\n"
+	return out
+}
+
+// actionLines marks the lines of tmpl holding part of a template action.
+func actionLines(tmpl []byte, left, right string) map[int]bool {
+	marked := map[int]bool{}
+	text := string(tmpl)
+	for offset := 0; ; {
+		start := strings.Index(text[offset:], left)
+		if start < 0 {
+			return marked
+		}
+		start += offset
+		end := strings.Index(text[start+len(left):], right)
+		if end < 0 {
+			end = len(text)
+		} else {
+			end += start + len(left) + len(right)
+		}
+		first := strings.Count(text[:start], "\n")
+		last := first + strings.Count(text[start:end], "\n")
+		for line := first; line <= last; line++ {
+			marked[line] = true
+		}
+		offset = end
+	}
+}
+
+// lineMatch pairs line a of one text with the identical line b of another.
+type lineMatch struct {
+	a, b int
+}
+
+// hunk replaces the lines [a0, a1) of one text with the lines [b0, b1) of
+// another.
+type hunk struct {
+	a0, a1, b0, b1 int
+}
+
+// lineDiff compares a and b line by line.
+func lineDiff(a, b []byte) []diffmatchpatch.Diff {
+	dmp := diffmatchpatch.New()
+	ra, rb, _ := dmp.DiffLinesToRunes(normalize(a), normalize(b))
+	return dmp.DiffMainRunes(ra, rb, false)
+}
+
+// normalize ends content with a newline, so that a missing one at the end
+// does not set the last line apart.
+func normalize(content []byte) string {
+	s := string(content)
+	if s != "" && !strings.HasSuffix(s, "\n") {
+		s += "\n"
+	}
+	return s
+}
+
+// alignLines returns the lines a and b have in common.
+func alignLines(a, b []byte) []lineMatch {
+	var matches []lineMatch
+	i, j := 0, 0
+	for _, d := range lineDiff(a, b) {
+		n := len([]rune(d.Text))
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			for k := 0; k < n; k++ {
+				matches = append(matches, lineMatch{i + k, j + k})
+			}
+			i, j = i+n, j+n
+		case diffmatchpatch.DiffDelete:
+			i += n
+		case diffmatchpatch.DiffInsert:
+			j += n
+		}
+	}
+	return matches
+}
+
+// diffHunks returns the changes from a to b. Lines replaced one for one
+// make a hunk each, so that a change to one line does not drag its
+// neighbours along.
+func diffHunks(a, b []byte) []hunk {
+	var hunks []hunk
+	for _, h := range diffBlocks(a, b) {
+		for h.a1-h.a0 > 1 && h.b1-h.b0 > 1 {
+			hunks = append(hunks, hunk{h.a0, h.a0 + 1, h.b0, h.b0 + 1})
+			h.a0++
+			h.b0++
+		}
+		hunks = append(hunks, h)
+	}
+	return hunks
+}
+
+// diffBlocks returns the changes from a to b, a hunk for each run of
+// changed lines.
+func diffBlocks(a, b []byte) []hunk {
+	var hunks []hunk
+	var cur *hunk
+	i, j := 0, 0
+	for _, d := range lineDiff(a, b) {
+		n := len([]rune(d.Text))
+		if d.Type == diffmatchpatch.DiffEqual {
+			if cur != nil {
+				hunks = append(hunks, *cur)
+				cur = nil
+			}
+			i, j = i+n, j+n
+			continue
+		}
+		if cur == nil {
+			cur = &hunk{a0: i, a1: i, b0: j, b1: j}
+		}
+		if d.Type == diffmatchpatch.DiffDelete {
+			i += n
+			cur.a1 = i
+		} else {
+			j += n
+			cur.b1 = j
+		}
+	}
+	if cur != nil {
+		hunks = append(hunks, *cur)
+	}
+	return hunks
+}
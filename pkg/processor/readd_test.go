@@ -0,0 +1,84 @@
+package processor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReAdd(t *testing.T) {
+	tests := []struct {
+		name      string
+		tmpl      string
+		rendered  string
+		edited    string
+		delims    [2]string
+		want      string
+		conflicts int
+	}{
+		{
+			name:     "Literal Lines Are Merged",
+			tmpl:     "[user]\n\tname = {{ .name }}\n\temail = me@example.com\n",
+			rendered: "[user]\n\tname = Bob\n\temail = me@example.com\n",
+			edited:   "[user]\n\tname = Bob\n\temail = bob@example.com\n\tsigningkey = ABC\n",
+			want:     "[user]\n\tname = {{ .name }}\n\temail = bob@example.com\n\tsigningkey = ABC\n",
+		},
+		{
+			name:      "Change To An Action Line Conflicts",
+			tmpl:      "alias ll='ls -l'\nalias g={{ .git }}\n",
+			rendered:  "alias ll='ls -l'\nalias g=git\n",
+			edited:    "alias ll='ls -la'\nalias g=hub\n",
+			want:      "alias ll='ls -la'\n" + ConflictStart + "\nalias g={{ .git }}\n" + ConflictSep + "\nalias g=hub\n" + ConflictEnd + "\n",
+			conflicts: 1,
+		},
+		{
+			name:      "Literal Lines Next To A Conflict Are Merged",
+			tmpl:      "a\n{{ .x }}\nb\n",
+			rendered:  "a\n1\nb\n",
+			edited:    "a\none\nB\n",
+			want:      "a\n" + ConflictStart + "\n{{ .x }}\n" + ConflictSep + "\none\n" + ConflictEnd + "\nB\n",
+			conflicts: 1,
+		},
+		{
+			name:      "Change Inside A Range Conflicts With The Whole Block",
+			tmpl:      "top\n{{ range .l }}\n- {{ . }}\n{{ end }}\nbottom\n",
+			rendered:  "top\n- 1\n- 2\nbottom\n",
+			edited:    "top\n- 1\n- 3\nbottom\n",
+			want:      "top\n" + ConflictStart + "\n{{ range .l }}\n- {{ . }}\n{{ end }}\n" + ConflictSep + "\n- 1\n- 3\n" + ConflictEnd + "\nbottom\n",
+			conflicts: 1,
+		},
+		{
+			name:      "Separate Conflicts Are Counted",
+			tmpl:      "{{ .a }}\nkeep\n{{ .b }}\n",
+			rendered:  "1\nkeep\n2\n",
+			edited:    "one\nkeep\ntwo\n",
+			want:      ConflictStart + "\n{{ .a }}\n" + ConflictSep + "\none\n" + ConflictEnd + "\nkeep\n" + ConflictStart + "\n{{ .b }}\n" + ConflictSep + "\ntwo\n" + ConflictEnd + "\n",
+			conflicts: 2,
+		},
+		{
+			name:      "Custom Delimiters",
+			tmpl:      "image: {{ .Values.image }}\nname: [[ .name ]]\n",
+			rendered:  "image: {{ .Values.image }}\nname: Bob\n",
+			edited:    "image: {{ .Values.tag }}\nname: Alice\n",
+			delims:    [2]string{"[[", "]]"},
+			want:      "image: {{ .Values.tag }}\n" + ConflictStart + "\nname: [[ .name ]]\n" + ConflictSep + "\nname: Alice\n" + ConflictEnd + "\n",
+			conflicts: 1,
+		},
+		{
+			name:      "Header Sets The Delimiters",
+			tmpl:      "# scadufax: delims=\"<< >>\"\nuser << .name >>\nset {{ x }}\n",
+			rendered:  "user Bob\nset {{ x }}\n",
+			edited:    "user Alice\nset {{ y }}\n",
+			want:      "# scadufax: delims=\"<< >>\"\n" + ConflictStart + "\nuser << .name >>\n" + ConflictSep + "\nuser Alice\n" + ConflictEnd + "\nset {{ y }}\n",
+			conflicts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, conflicts := ReAdd([]byte(tt.tmpl), []byte(tt.rendered), []byte(tt.edited), tt.delims[0], tt.delims[1])
+			assert.Equal(t, tt.want, string(got))
+			assert.Equal(t, tt.conflicts, conflicts)
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package processor
+
+import (
+	"fmt"
+	"log/slog"
+	"text/template"
+)
+
+// RendererOptions configures a Renderer. The zero value renders with the
+// built-in functions, the standard delimiters and no data, and fails on
+// missing keys and on every call to the secret function.
+type RendererOptions struct {
+	// Data is merged from every source in order: keys of later sources
+	// override those of earlier ones, and tables are merged key by key.
+	Data []map[string]any
+	// Secrets backs the secret function, e.g. the Lookup method of a
+	// secrets.Registry.
+	Secrets SecretFunc
+	// Funcs are added to the function library, replacing built-in
+	// functions of the same name. secret and include cannot be replaced.
+	// Shared templates (see LoadIncludes) cannot call them. Setting any
+	// turns off WithCache: their results may change from call to call.
+	Funcs template.FuncMap
+	// LeftDelim and RightDelim replace "{{" and "}}" when set.
+	LeftDelim, RightDelim string
+	// Lenient renders keys missing from the data as "<no value>" instead
+	// of failing.
+	Lenient bool
+	// Logger receives a debug record for every file rendered. Nil discards
+	// them.
+	Logger *slog.Logger
+	// Options are applied on top, e.g. WithIncludes or WithTemplateSuffix.
+	Options []Option
+}
+
+// Renderer renders templates with a fixed configuration. It is safe for
+// concurrent use.
+type Renderer struct {
+	e *engine
+}
+
+// NewRenderer returns a Renderer configured by opts.
+func NewRenderer(opts RendererOptions) (*Renderer, error) {
+	if (opts.LeftDelim == "") != (opts.RightDelim == "") {
+		return nil, fmt.Errorf("delimiters must be set together, got %q and %q", opts.LeftDelim, opts.RightDelim)
+	}
+
+	o := newOptions(append([]Option{func(o *options) {
+		o.funcs = opts.Funcs
+		o.left, o.right = opts.LeftDelim, opts.RightDelim
+		o.lenient = opts.Lenient
+		o.logger = opts.Logger
+	}}, opts.Options...))
+
+	secretFn := opts.Secrets
+	if secretFn == nil {
+		secretFn = func(key string, _ ...string) (string, error) {
+			return "", fmt.Errorf("secret %q: no secret provider configured", key)
+		}
+	}
+
+	data := map[string]any{}
+	for _, source := range opts.Data {
+		mergeData(data, source)
+	}
+
+	e, err := newEngine(data, secretFn, o)
+	if err != nil {
+		return nil, err
+	}
+	return &Renderer{e: e}, nil
+}
+
+// Render executes content as the template name. A header may set its
+// delimiters, as with WithFileDelims.
+func (r *Renderer) Render(name string, content []byte) ([]byte, error) {
+	out, _, err := r.e.render(name, content, delims{})
+	return out, err
+}
+
+// RenderFile reifies the file at src into dst, like ReifyFile.
+func (r *Renderer) RenderFile(src, dst string) error {
+	return r.e.reifyFile(src, dst)
+}
+
+// RenderTree reifies the tree at src into dst, like ReifyTree, or in place,
+// like Reify, when src and dst are the same.
+func (r *Renderer) RenderTree(src, dst string, dryRun bool) (*Report, error) {
+	if src != dst {
+		if err := prepareTrees(src, dst, dryRun); err != nil {
+			return nil, err
+		}
+	}
+	return r.e.reifyTree(src, dst, dryRun)
+}
+
+// Lint checks content as the template name against the renderer's data,
+// like Lint.
+func (r *Renderer) Lint(name string, content []byte) []Problem {
+	return lint(name, content, r.e.data, r.e.o)
+}
+
+// Prompts lists the values the templates under src read and the
+// renderer's data lacks, like FindPrompts.
+func (r *Renderer) Prompts(src string) ([]Prompt, error) {
+	return findPrompts(src, r.e.data, r.e.o)
+}
+
+// mergeData merges src into dst, table by table.
+func mergeData(dst, src map[string]any) {
+	for key, value := range src {
+		table, isTable := value.(map[string]any)
+		current, hasTable := dst[key].(map[string]any)
+		if isTable && hasTable {
+			mergeData(current, table)
+			continue
+		}
+		if isTable {
+			// Copied, so merging into it later leaves src alone
+			copied := map[string]any{}
+			mergeData(copied, table)
+			value = copied
+		}
+		dst[key] = value
+	}
+}
@@ -0,0 +1,86 @@
+package processor
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ScriptsDir holds the scripts run after files are installed, relative to
+// the repository root.
+var ScriptsDir = filepath.Join(MetaDir, "scripts")
+
+// ScriptKind is when a script runs.
+type ScriptKind int
+
+const (
+	// ScriptOnce runs once on every machine. Its name starts with
+	// "run_once_".
+	ScriptOnce ScriptKind = iota
+	// ScriptOnChange runs again whenever its rendered content changes. Its
+	// name starts with "run_onchange_".
+	ScriptOnChange
+)
+
+func (k ScriptKind) String() string {
+	switch k {
+	case ScriptOnce:
+		return "once"
+	case ScriptOnChange:
+		return "onchange"
+	}
+	return fmt.Sprintf("ScriptKind(%d)", int(k))
+}
+
+// Script is a script to run after installing files.
+type Script struct {
+	// Name is its path relative to the scripts directory, slash separated,
+	// without the template suffix.
+	Name string
+	Kind ScriptKind
+	// Path is the file holding it.
+	Path string
+	// Template is set when it is rendered before it runs.
+	Template bool
+}
+
+// FindScripts lists the scripts under dir, in the order they run: by path.
+// Files whose names start with neither "run_once_" nor "run_onchange_" are
+// left out, e.g. helpers the scripts source. suffix is the template suffix,
+// as in IsTemplate. A missing dir holds no scripts.
+func FindScripts(dir, suffix string) ([]Script, error) {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	var scripts []Script
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		s := Script{Name: TargetName(rel, suffix), Path: p, Template: IsTemplate(rel, suffix)}
+		switch base := path.Base(s.Name); {
+		case strings.HasPrefix(base, "run_once_"):
+			s.Kind = ScriptOnce
+		case strings.HasPrefix(base, "run_onchange_"):
+			s.Kind = ScriptOnChange
+		default:
+			return nil
+		}
+		scripts = append(scripts, s)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find scripts: %w", err)
+	}
+	return scripts, nil
+}
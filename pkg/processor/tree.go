@@ -0,0 +1,306 @@
+package processor
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/suderio/scadufax/pkg/crypt"
+)
+
+// Action is what reifying a file amounts to.
+type Action int
+
+const (
+	// ActionCopy copies (or, in place, moves) the file verbatim.
+	ActionCopy Action = iota
+	// ActionRender renders the file as a template.
+	ActionRender
+	// ActionDecrypt decrypts the file, then renders it if it is a text
+	// template. In place, the output is sealed to the recipient; without
+	// one the file is kept encrypted as is, and an encrypted template that
+	// renders to something else is an error.
+	ActionDecrypt
+	// ActionRemove removes the file from a tree reified in place: another
+	// alternate was selected.
+	ActionRemove
+	// ActionSkip leaves the file out: it is ignored, excluded by the filter,
+	// an alternate that was not selected, or, in place, encrypted with no
+	// key to decrypt it.
+	ActionSkip
+	// ActionLink recreates a symbolic link, pointing where the source does,
+	// rather than copying what it points to.
+	ActionLink
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionCopy:
+		return "copy"
+	case ActionRender:
+		return "render"
+	case ActionDecrypt:
+		return "decrypt"
+	case ActionRemove:
+		return "remove"
+	case ActionSkip:
+		return "skip"
+	case ActionLink:
+		return "link"
+	}
+	return fmt.Sprintf("Action(%d)", int(a))
+}
+
+// Report lists what reifying a tree did to each of its files, in walk order.
+type Report struct {
+	Files []FileResult
+}
+
+// FileResult is what reifying one file did.
+type FileResult struct {
+	// Source is the file's path relative to the source tree, slash separated.
+	Source string
+	// Target is the path it is reified to, relative to the destination
+	// tree. It is empty for skipped and removed files.
+	Target string
+	Action Action
+	// Changed is set when the target is created or modified, or would be
+	// on a dry run.
+	Changed bool
+	// Err is why the file could not be rendered.
+	Err error
+}
+
+// Changed returns the results of the files whose target changed.
+func (r *Report) Changed() []FileResult {
+	var changed []FileResult
+	for _, f := range r.Files {
+		if f.Changed {
+			changed = append(changed, f)
+		}
+	}
+	return changed
+}
+
+// ReifyTree reifies the tree at src into dst, leaving src untouched: each
+// file is rendered, decrypted or copied to its target name under dst. Files
+// in dst that src does not produce are left alone. Like Reify, every file is
+// rendered before anything is written, and dst is updated as a transaction.
+// If dryRun is true, nothing is written. The report is returned even on
+// failure, with the error of every file that failed to render.
+func ReifyTree(src, dst string, data map[string]any, secretFn SecretFunc, dryRun bool, opts ...Option) (*Report, error) {
+	if err := prepareTrees(src, dst, dryRun); err != nil {
+		return nil, err
+	}
+	e, err := newEngine(data, secretFn, newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	return e.reifyTree(src, dst, dryRun)
+}
+
+// prepareTrees checks that src is a directory and creates dst, unless on a
+// dry run.
+func prepareTrees(src, dst string, dryRun bool) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", src)
+	}
+	if dryRun {
+		return nil
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dst, err)
+	}
+	return nil
+}
+
+func (e *engine) reifyTree(src, dst string, dryRun bool) (*Report, error) {
+	// A single file is staged next to it
+	txnRoot := dst
+	if info, err := os.Stat(dst); err == nil && !info.IsDir() {
+		txnRoot = filepath.Dir(dst)
+	}
+
+	// A reify that was interrupted left the tree half done
+	if err := recoverTransaction(txnRoot); err != nil {
+		return nil, err
+	}
+
+	jobs, err := planTree(src, dst, e.o)
+	if err != nil {
+		return nil, fmt.Errorf("dry-run failed: %w", err)
+	}
+
+	report := &Report{Files: make([]FileResult, len(jobs))}
+	results := e.run(jobs)
+	var firstErr error
+	for i, j := range jobs {
+		report.Files[i] = FileResult{Source: j.rel, Target: j.target, Action: j.act, Err: results[i].err}
+		if results[i].err != nil && firstErr == nil {
+			firstErr = results[i].err
+		}
+	}
+	if firstErr != nil {
+		return report, fmt.Errorf("dry-run failed: %w", firstErr)
+	}
+
+	// Stage every change, then apply them all or none
+	var t *transaction
+	if !dryRun {
+		if t, err = beginTransaction(txnRoot); err != nil {
+			return report, err
+		}
+		defer t.close()
+	}
+
+	// Unselected alternates go first: the selected one may take their name
+	for _, removing := range []bool{true, false} {
+		for i, j := range jobs {
+			if (j.act == ActionRemove) != removing {
+				continue
+			}
+			if report.Files[i].Changed, err = e.stage(t, j, results[i]); err != nil {
+				return report, err
+			}
+			e.o.logger.Debug("reify", "source", j.rel, "target", j.target, "action", j.act, "changed", report.Files[i].Changed)
+		}
+	}
+	if dryRun {
+		return report, nil
+	}
+	if err := t.commit(); err != nil {
+		return report, fmt.Errorf("failed to reify %s, changes rolled back: %w", dst, err)
+	}
+	return report, ensureDirs(txnRoot, e.o.dirs)
+}
+
+// planTree lists what reifying the tree at src into dst amounts to, file by
+// file, in walk order. When src is dst the tree is reified in place.
+func planTree(src, dst string, o *options) ([]job, error) {
+	inPlace := src == dst
+
+	var alts *Alternates
+	if o.machine != nil {
+		var err error
+		if alts, err = FindAlternates(src, o.suffix, *o.machine); err != nil {
+			return nil, err
+		}
+	}
+
+	var jobs []job
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" || rel == MetaDir || (!inPlace && path == dst) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		j := job{src: path, rel: filepath.ToSlash(rel), act: ActionSkip}
+
+		target, ok := alts.Target(TargetName(filepath.ToSlash(rel), o.suffix))
+		if !ok {
+			// Another variant of the file was selected for this machine
+			if inPlace {
+				j.act = ActionRemove
+			}
+			jobs = append(jobs, j)
+			return nil
+		}
+		if isIgnored(target, o.ignores) {
+			jobs = append(jobs, j)
+			return nil
+		}
+		if o.filter != nil {
+			ok, err := o.filter(target)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				jobs = append(jobs, j)
+				return nil
+			}
+		}
+
+		// In place, the file is processed under its target name, then the
+		// template is dropped so the tree only holds what would be installed
+		j.target = target
+		j.dst = filepath.Join(dst, filepath.FromSlash(target))
+		j.act = ActionCopy
+		j.move = inPlace
+		if d.Type()&fs.ModeSymlink != 0 {
+			j.act = ActionLink
+			jobs = append(jobs, j)
+			return nil
+		}
+		if o.fileDelims != nil {
+			j.delims.left, j.delims.right = o.fileDelims(target)
+		}
+		if o.perms != nil {
+			if perm, ok := o.perms(target); ok {
+				j.perm = perm
+			}
+		}
+		if !IsTemplate(path, o.suffix) {
+			// Copied verbatim, but an alternate still moves to its base name
+			jobs = append(jobs, j)
+			return nil
+		}
+
+		encrypted, err := crypt.IsEncryptedFile(path)
+		if err != nil {
+			return err
+		}
+		if encrypted {
+			// Without the key the file stays encrypted, to be decrypted on install
+			if o.key != nil {
+				j.act = ActionDecrypt
+			} else if inPlace {
+				j.act, j.target = ActionSkip, ""
+			}
+			jobs = append(jobs, j)
+			return nil
+		}
+
+		binary, err := IsBinary(path)
+		if err != nil {
+			return err
+		}
+		if !binary {
+			// Nothing to render in a binary, only a template suffix to drop
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			j.act = ActionRender
+			if j.perm == 0 {
+				j.perm = info.Mode()
+			}
+		}
+		jobs = append(jobs, j)
+		return nil
+	})
+	return jobs, err
+}
+
+// isIgnored reports whether target, slash separated, matches one of
+// patterns.
+func isIgnored(target string, patterns []string) bool {
+	for _, p := range patterns {
+		if matched, _ := filepath.Match(p, filepath.FromSlash(target)); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,325 @@
+package processor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// TxnDir is where Reify stages the changes to a tree, relative to its root.
+// It only exists while a reify is in progress, or after one was interrupted;
+// the next Reify of the tree then rolls it back.
+var TxnDir = filepath.Join(MetaDir, "reify")
+
+const journalName = "journal.json"
+
+// rename is a step of a transaction: moving From to To.
+type rename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// journal is what a transaction does to a tree: the directories it created,
+// then the renames it carries out, in order. Paths are absolute in memory and
+// relative to the root on disk.
+type journal struct {
+	Dirs    []string `json:"dirs,omitempty"`
+	Renames []rename `json:"renames"`
+}
+
+// transaction applies changes to a tree as a journal of renames. New content
+// is staged in TxnDir and every file replaced or removed is moved there
+// first, so that undoing the renames in reverse restores the tree.
+type transaction struct {
+	root    string
+	dir     string
+	journal journal
+	staged  int
+
+	// What the journal does to the tree, before it is carried out
+	created map[string]bool
+	gone    map[string]bool
+
+	// done is set once the journal is carried out. broken is set when a
+	// rollback failed: the staging directory then holds the only copy of
+	// some files.
+	done, broken bool
+}
+
+// beginTransaction starts a transaction on the tree at root.
+func beginTransaction(root string) (*transaction, error) {
+	dir := filepath.Join(root, TxnDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	return &transaction{root: root, dir: dir, created: map[string]bool{}, gone: map[string]bool{}}, nil
+}
+
+// recoverTransaction rolls back the transaction an interrupted Reify left in
+// root, if any.
+func recoverTransaction(root string) error {
+	dir := filepath.Join(root, TxnDir)
+	raw, err := os.ReadFile(filepath.Join(dir, journalName))
+	if errors.Is(err, fs.ErrNotExist) {
+		// Nothing was renamed yet: only staged files to drop
+		return cleanTxnDir(root)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read reify journal: %w", err)
+	}
+
+	t := &transaction{root: root, dir: dir}
+	if err := json.Unmarshal(raw, &t.journal); err != nil {
+		return fmt.Errorf("failed to read reify journal: %w", err)
+	}
+	if err := t.journal.resolve(root); err != nil {
+		return fmt.Errorf("failed to read reify journal: %w", err)
+	}
+	if err := t.rollback(); err != nil {
+		return fmt.Errorf("failed to roll back interrupted reify: %w", err)
+	}
+	return cleanTxnDir(root)
+}
+
+// cleanTxnDir removes TxnDir, and MetaDir with it if nothing else is there.
+func cleanTxnDir(root string) error {
+	if err := os.RemoveAll(filepath.Join(root, TxnDir)); err != nil {
+		return err
+	}
+	// Fails, as meant, when MetaDir holds anything else
+	os.Remove(filepath.Join(root, MetaDir))
+	return nil
+}
+
+// next returns a new path in the staging directory.
+func (t *transaction) next() string {
+	t.staged++
+	return filepath.Join(t.dir, fmt.Sprintf("%d", t.staged))
+}
+
+// exists reports whether path exists once the journal so far is carried out.
+func (t *transaction) exists(path string) bool {
+	if t.created[path] {
+		return true
+	}
+	if t.gone[path] {
+		return false
+	}
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// mkdirs creates the missing parents of path, to be removed on rollback.
+// They are journaled first, so that an interrupted reify removes them too.
+func (t *transaction) mkdirs(path string) error {
+	var missing []string
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); err == nil || dir == filepath.Dir(dir) {
+			break
+		}
+		missing = append(missing, dir)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		t.journal.Dirs = append(t.journal.Dirs, missing[i])
+	}
+	if err := t.save(); err != nil {
+		return err
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := os.Mkdir(missing[i], 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copy stages a copy of src to replace dst, with perm unless it is zero.
+func (t *transaction) copy(src, dst string, perm fs.FileMode) error {
+	tmp := t.next()
+	if err := CopyFile(src, tmp); err != nil {
+		return err
+	}
+	if perm != 0 {
+		if err := os.Chmod(tmp, perm); err != nil {
+			return err
+		}
+	}
+	return t.move(tmp, dst)
+}
+
+// symlink stages a link to dest to replace path.
+func (t *transaction) symlink(dest, path string) error {
+	tmp := t.next()
+	if err := os.Symlink(dest, tmp); err != nil {
+		return err
+	}
+	return t.move(tmp, path)
+}
+
+// write stages content to replace path, with perm.
+func (t *transaction) write(path string, content []byte, perm fs.FileMode) error {
+	tmp := t.next()
+	if err := writeSynced(tmp, content); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		return err
+	}
+	return t.move(tmp, path)
+}
+
+// move renames from to to, replacing what is there.
+func (t *transaction) move(from, to string) error {
+	if err := t.mkdirs(to); err != nil {
+		return err
+	}
+	if t.exists(to) {
+		t.remove(to)
+	}
+	t.journal.Renames = append(t.journal.Renames, rename{From: from, To: to})
+	t.gone[from], t.created[from] = true, false
+	t.created[to], t.gone[to] = true, false
+	return nil
+}
+
+// remove moves path out of the tree.
+func (t *transaction) remove(path string) {
+	t.journal.Renames = append(t.journal.Renames, rename{From: path, To: t.next()})
+	t.gone[path], t.created[path] = true, false
+}
+
+// save records the journal in the staging directory. Renames not carried
+// out yet are skipped by a rollback.
+func (t *transaction) save() error {
+	var j journal
+	for _, dir := range t.journal.Dirs {
+		rel, err := filepath.Rel(t.root, dir)
+		if err != nil {
+			return err
+		}
+		j.Dirs = append(j.Dirs, filepath.ToSlash(rel))
+	}
+	for _, r := range t.journal.Renames {
+		from, err := filepath.Rel(t.root, r.From)
+		if err != nil {
+			return err
+		}
+		to, err := filepath.Rel(t.root, r.To)
+		if err != nil {
+			return err
+		}
+		j.Renames = append(j.Renames, rename{From: filepath.ToSlash(from), To: filepath.ToSlash(to)})
+	}
+
+	raw, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	if err := writeSynced(filepath.Join(t.dir, journalName), raw); err != nil {
+		return fmt.Errorf("failed to write reify journal: %w", err)
+	}
+	return nil
+}
+
+// resolve turns the paths of a journal read from disk into paths under
+// root, refusing any that would leave it.
+func (j *journal) resolve(root string) error {
+	abs := func(rel string) (string, error) {
+		if !filepath.IsLocal(filepath.FromSlash(rel)) {
+			return "", fmt.Errorf("path %q is outside the tree", rel)
+		}
+		return filepath.Join(root, filepath.FromSlash(rel)), nil
+	}
+	var err error
+	for i := range j.Dirs {
+		if j.Dirs[i], err = abs(j.Dirs[i]); err != nil {
+			return err
+		}
+	}
+	for i := range j.Renames {
+		if j.Renames[i].From, err = abs(j.Renames[i].From); err != nil {
+			return err
+		}
+		if j.Renames[i].To, err = abs(j.Renames[i].To); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commit records the journal, then carries it out. On failure every rename
+// already done is undone.
+func (t *transaction) commit() error {
+	if err := t.save(); err != nil {
+		return err
+	}
+
+	for _, r := range t.journal.Renames {
+		if err := os.Rename(r.From, r.To); err != nil {
+			if rbErr := t.rollback(); rbErr != nil {
+				t.broken = true
+				return fmt.Errorf("%w; rollback failed, see %s: %v", err, t.dir, rbErr)
+			}
+			return err
+		}
+	}
+	t.done = true
+	return nil
+}
+
+// close drops the staging directory, unless a failed rollback left files
+// there. A transaction that was never committed leaves the tree as it was.
+func (t *transaction) close() {
+	if t.broken {
+		return
+	}
+	if !t.done {
+		t.rollback()
+	}
+	cleanTxnDir(t.root)
+}
+
+// rollback undoes, in reverse, every rename of the journal that was done,
+// and removes the directories it created.
+func (t *transaction) rollback() error {
+	for i := len(t.journal.Renames) - 1; i >= 0; i-- {
+		r := t.journal.Renames[i]
+		if _, err := os.Lstat(r.From); err == nil {
+			continue
+		}
+		if _, err := os.Lstat(r.To); err != nil {
+			continue
+		}
+		if err := os.Rename(r.To, r.From); err != nil {
+			return err
+		}
+	}
+	for i := len(t.journal.Dirs) - 1; i >= 0; i-- {
+		// Fails, as meant, when something else was put there since
+		os.Remove(t.journal.Dirs[i])
+	}
+	return nil
+}
+
+// writeSynced writes content to path and flushes it to disk.
+func writeSynced(path string, content []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
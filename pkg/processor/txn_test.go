@@ -0,0 +1,106 @@
+package processor
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecoverTransaction(t *testing.T) {
+	t.Run("Interrupted Journal Is Rolled Back", func(t *testing.T) {
+		root := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(root, ".bashrc"), []byte("old"), 0644))
+
+		txn, err := beginTransaction(root)
+		require.NoError(t, err)
+		require.NoError(t, txn.write(filepath.Join(root, ".bashrc"), []byte("new"), 0644))
+		require.NoError(t, txn.write(filepath.Join(root, ".config", "app", "conf"), []byte("conf"), 0644))
+		require.NoError(t, txn.save())
+
+		// The journal is kept relative to the root
+		raw, err := os.ReadFile(filepath.Join(root, TxnDir, journalName))
+		require.NoError(t, err)
+		var j journal
+		require.NoError(t, json.Unmarshal(raw, &j))
+		assert.Equal(t, []string{".config", ".config/app"}, j.Dirs)
+		for _, r := range j.Renames {
+			assert.True(t, filepath.IsLocal(r.From), r.From)
+			assert.True(t, filepath.IsLocal(r.To), r.To)
+		}
+
+		// Interrupted before its last rename
+		renames := txn.journal.Renames
+		require.Len(t, renames, 3)
+		for _, r := range renames[:len(renames)-1] {
+			require.NoError(t, os.Rename(r.From, r.To))
+		}
+		content, err := os.ReadFile(filepath.Join(root, ".bashrc"))
+		require.NoError(t, err)
+		assert.Equal(t, "new", string(content))
+
+		require.NoError(t, recoverTransaction(root))
+
+		content, err = os.ReadFile(filepath.Join(root, ".bashrc"))
+		require.NoError(t, err)
+		assert.Equal(t, "old", string(content))
+		assert.NoDirExists(t, filepath.Join(root, ".config"))
+		assert.NoDirExists(t, filepath.Join(root, MetaDir))
+	})
+
+	t.Run("Staged Files Without A Journal Are Dropped", func(t *testing.T) {
+		root := t.TempDir()
+		txn, err := beginTransaction(root)
+		require.NoError(t, err)
+		require.NoError(t, txn.write(filepath.Join(root, ".bashrc"), []byte("new"), 0644))
+
+		require.NoError(t, recoverTransaction(root))
+		assert.NoFileExists(t, filepath.Join(root, ".bashrc"))
+		assert.NoDirExists(t, filepath.Join(root, MetaDir))
+	})
+
+	t.Run("Moved Tree Is Recovered In Place", func(t *testing.T) {
+		// Relative paths keep working once the tree moves
+		old := filepath.Join(t.TempDir(), "home")
+		require.NoError(t, os.MkdirAll(old, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(old, ".bashrc"), []byte("old"), 0644))
+
+		txn, err := beginTransaction(old)
+		require.NoError(t, err)
+		require.NoError(t, txn.write(filepath.Join(old, ".bashrc"), []byte("new"), 0644))
+		require.NoError(t, txn.save())
+		for _, r := range txn.journal.Renames {
+			require.NoError(t, os.Rename(r.From, r.To))
+		}
+
+		root := filepath.Join(t.TempDir(), "moved")
+		require.NoError(t, os.Rename(old, root))
+		require.NoError(t, recoverTransaction(root))
+
+		content, err := os.ReadFile(filepath.Join(root, ".bashrc"))
+		require.NoError(t, err)
+		assert.Equal(t, "old", string(content))
+	})
+
+	t.Run("Paths Outside The Tree Are Refused", func(t *testing.T) {
+		parent := t.TempDir()
+		root := filepath.Join(parent, "home")
+		outside := filepath.Join(parent, "outside")
+		require.NoError(t, os.WriteFile(outside, []byte("keep"), 0644))
+		require.NoError(t, os.MkdirAll(filepath.Join(root, TxnDir), 0700))
+		raw, err := json.Marshal(journal{Renames: []rename{{From: "../outside", To: ".bashrc"}}})
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(root, TxnDir, journalName), raw, 0600))
+		require.NoError(t, os.WriteFile(filepath.Join(root, ".bashrc"), []byte("moved"), 0644))
+
+		err = recoverTransaction(root)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "outside the tree")
+		content, err := os.ReadFile(outside)
+		require.NoError(t, err)
+		assert.Equal(t, "keep", string(content))
+	})
+}
@@ -0,0 +1,139 @@
+package secrets
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/joho/godotenv"
+)
+
+// Dotenv reads secrets from a .env file. The file is only read on the first
+// lookup, so it need not exist unless the backend is used.
+type Dotenv struct {
+	path     string
+	required bool
+
+	once   sync.Once
+	values map[string]string
+	err    error
+}
+
+// NewDotenv returns the backend reading the .env file at path. If required
+// is false a missing file is treated as empty; any other read error is
+// returned by Lookup.
+func NewDotenv(path string, required bool) *Dotenv {
+	return &Dotenv{path: path, required: required}
+}
+
+// Lookup implements Provider.
+func (d *Dotenv) Lookup(key string) (string, error) {
+	d.once.Do(func() {
+		values, err := godotenv.Read(d.path)
+		if err != nil && (d.required || !os.IsNotExist(err)) {
+			d.err = fmt.Errorf("failed to read .env at %s: %w", d.path, err)
+		}
+		d.values = values
+	})
+	if d.err != nil {
+		return "", d.err
+	}
+	val, ok := d.values[key]
+	if !ok {
+		return "", fmt.Errorf("secret key %q not found in .env at %s: %w", key, d.path, ErrNotFound)
+	}
+	return val, nil
+}
+
+// Env reads secrets from environment variables, optionally prefixed
+// (with Prefix "SCADU_", the key "TOKEN" reads $SCADU_TOKEN).
+type Env struct {
+	Prefix string
+}
+
+// Lookup implements Provider.
+func (e Env) Lookup(key string) (string, error) {
+	val, ok := os.LookupEnv(e.Prefix + key)
+	if !ok {
+		return "", fmt.Errorf("secret key %q not found in environment variable %s: %w", key, e.Prefix+key, ErrNotFound)
+	}
+	return val, nil
+}
+
+// KeyPlaceholder is replaced by the secret key in Command arguments.
+const KeyPlaceholder = "{key}"
+
+// Command runs an executable and reads the secret from its standard output,
+// e.g. ["pass", "show", "{key}"]. If no argument contains KeyPlaceholder the
+// key is appended as the last argument. A single trailing newline is
+// stripped from the output. Results are cached per key.
+type Command struct {
+	Args []string
+
+	mu    sync.Mutex
+	cache map[string]string
+}
+
+// NewCommand returns a Command provider running args.
+func NewCommand(args []string) (*Command, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("command secret backend needs a command")
+	}
+	return &Command{Args: args, cache: map[string]string{}}, nil
+}
+
+// Lookup implements Provider.
+func (c *Command) Lookup(key string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if val, ok := c.cache[key]; ok {
+		return val, nil
+	}
+
+	args := make([]string, 0, len(c.Args)+1)
+	substituted := false
+	for _, arg := range c.Args {
+		if strings.Contains(arg, KeyPlaceholder) {
+			arg = strings.ReplaceAll(arg, KeyPlaceholder, key)
+			substituted = true
+		}
+		args = append(args, arg)
+	}
+	if !substituted {
+		args = append(args, key)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("secret command %v failed for key %q: %w: %s", c.Args, key, err, strings.TrimSpace(stderr.String()))
+	}
+
+	val := strings.TrimSuffix(stdout.String(), "\n")
+	val = strings.TrimSuffix(val, "\r")
+	c.cache[key] = val
+	return val, nil
+}
+
+// Chain tries each provider in order and returns the first value found.
+// Errors other than ErrNotFound stop the search.
+type Chain []Provider
+
+// Lookup implements Provider.
+func (c Chain) Lookup(key string) (string, error) {
+	for _, p := range c {
+		val, err := p.Lookup(key)
+		if err == nil {
+			return val, nil
+		}
+		if !isNotFound(err) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("secret key %q not found in any chained backend: %w", key, ErrNotFound)
+}
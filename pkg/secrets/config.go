@@ -0,0 +1,121 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Config is the [secrets] section of config.toml / local.toml.
+//
+//	[secrets]
+//	default = "chain"
+//
+//	[secrets.providers.pass]
+//	type = "command"
+//	command = ["pass", "show", "{key}"]
+//
+//	[secrets.providers.chain]
+//	type = "chain"
+//	providers = ["env", "pass", "dotenv"]
+type Config struct {
+	Default   string                    `mapstructure:"default"`
+	Providers map[string]ProviderConfig `mapstructure:"providers"`
+}
+
+// ProviderConfig configures one named backend. Which fields apply depends on
+// Type: "dotenv" (Path, Required), "env" (Prefix), "command" (Command) or
+// "chain" (Providers).
+type ProviderConfig struct {
+	Type      string   `mapstructure:"type"`
+	Path      string   `mapstructure:"path"`
+	Required  bool     `mapstructure:"required"`
+	Prefix    string   `mapstructure:"prefix"`
+	Command   []string `mapstructure:"command"`
+	Providers []string `mapstructure:"providers"`
+}
+
+// Build returns a registry holding builtins plus the providers configured in
+// cfg, which may override builtins of the same name. Without a configured
+// default, fallback is used.
+func Build(cfg Config, builtins map[string]Provider, fallback string) (*Registry, error) {
+	def := cfg.Default
+	if def == "" {
+		def = fallback
+	}
+	r := NewRegistry(def)
+	for name, p := range builtins {
+		r.Register(name, p)
+	}
+
+	// Chains may reference any other provider, so build them last
+	var chains []string
+	for name, pc := range cfg.Providers {
+		if pc.Type == "chain" {
+			chains = append(chains, name)
+			continue
+		}
+		p, err := newProvider(pc)
+		if err != nil {
+			return nil, fmt.Errorf("secret backend %q: %w", name, err)
+		}
+		r.Register(name, p)
+	}
+	// Resolve chains until all are built; a chain may contain other chains
+	for len(chains) > 0 {
+		var pending []string
+		var lastErr error
+		for _, name := range chains {
+			chain, err := buildChain(r, name, cfg.Providers[name].Providers)
+			if err != nil {
+				pending = append(pending, name)
+				lastErr = err
+				continue
+			}
+			r.Register(name, chain)
+		}
+		if len(pending) == len(chains) {
+			return nil, lastErr
+		}
+		chains = pending
+	}
+
+	if _, err := r.Get(def); err != nil {
+		return nil, fmt.Errorf("default secret backend: %w", err)
+	}
+	return r, nil
+}
+
+func buildChain(r *Registry, name string, members []string) (Chain, error) {
+	var chain Chain
+	for _, member := range members {
+		if member == name {
+			return nil, fmt.Errorf("secret backend %q: chain cannot include itself", name)
+		}
+		p, err := r.Get(member)
+		if err != nil {
+			return nil, fmt.Errorf("secret backend %q: %w", name, err)
+		}
+		chain = append(chain, p)
+	}
+	return chain, nil
+}
+
+func newProvider(pc ProviderConfig) (Provider, error) {
+	switch pc.Type {
+	case "dotenv":
+		if pc.Path == "" {
+			return nil, fmt.Errorf("dotenv backend needs a path")
+		}
+		return NewDotenv(pc.Path, pc.Required), nil
+	case "env":
+		return Env{Prefix: pc.Prefix}, nil
+	case "command":
+		return NewCommand(pc.Command)
+	default:
+		return nil, fmt.Errorf("unknown type %q", pc.Type)
+	}
+}
+
+func isNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
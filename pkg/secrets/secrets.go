@@ -0,0 +1,75 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrNotFound is returned (wrapped) by a Provider that does not hold a key.
+var ErrNotFound = errors.New("secret not found")
+
+// Provider looks up secret values by key.
+type Provider interface {
+	Lookup(key string) (string, error)
+}
+
+// Registry holds the named providers available to templates. Lookup is the
+// `secret` template function: {{ secret "KEY" }} asks the default provider,
+// {{ secret "KEY" "cmd" }} asks the provider registered as "cmd".
+type Registry struct {
+	providers map[string]Provider
+	def       string
+}
+
+// NewRegistry returns an empty registry whose default provider is def.
+func NewRegistry(def string) *Registry {
+	return &Registry{providers: map[string]Provider{}, def: def}
+}
+
+// Register adds p under name, replacing any provider of the same name.
+func (r *Registry) Register(name string, p Provider) {
+	r.providers[name] = p
+}
+
+// SetDefault selects the provider used when a template does not name one.
+func (r *Registry) SetDefault(name string) {
+	r.def = name
+}
+
+// Names returns the registered provider names, sorted.
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns the provider registered as name.
+func (r *Registry) Get(name string) (Provider, error) {
+	p, ok := r.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown secret backend %q (available: %v)", name, r.Names())
+	}
+	return p, nil
+}
+
+// Lookup returns the value of key from the named backend, or from the default
+// backend if none is given.
+func (r *Registry) Lookup(key string, backend ...string) (string, error) {
+	name := r.def
+	if len(backend) > 1 {
+		return "", fmt.Errorf("secret %q: expected at most one backend, got %v", key, backend)
+	}
+	if len(backend) == 1 {
+		name = backend[0]
+	}
+
+	p, err := r.Get(name)
+	if err != nil {
+		return "", err
+	}
+	return p.Lookup(key)
+}
@@ -0,0 +1,111 @@
+// Package state keeps what scadufax did on a machine between runs, outside
+// the repository: the files it installed, and the scripts it ran, with what
+// content.
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// FileName is the name of the state file inside the state directory.
+const FileName = "state.json"
+
+// State is what scadufax recorded on this machine.
+type State struct {
+	// Files maps the path of every file installed, slash separated and
+	// relative to home, to how it was installed last.
+	Files map[string]File `json:"files,omitempty"`
+	// Scripts maps the name of every script that ran to the Sum of the
+	// content it last ran with.
+	Scripts map[string]string `json:"scripts,omitempty"`
+
+	path string
+}
+
+// File is a file as it was installed in home.
+type File struct {
+	// Sum is the Sum of its content: the plain text of an encrypted file,
+	// or where a link points for a link.
+	Sum  string      `json:"sum"`
+	Mode fs.FileMode `json:"mode,omitempty"`
+	// ID is the SCADUFAX_ID of the commit it was installed from, if known.
+	ID string `json:"id,omitempty"`
+}
+
+// Load reads the state at path. A missing state is empty.
+func Load(path string) (*State, error) {
+	s := &State{Files: map[string]File{}, Scripts: map[string]string{}, path: path}
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state: %w", err)
+	}
+	if err := json.Unmarshal(raw, s); err != nil {
+		return nil, fmt.Errorf("invalid state %s: %w", path, err)
+	}
+	if s.Files == nil {
+		s.Files = map[string]File{}
+	}
+	if s.Scripts == nil {
+		s.Scripts = map[string]string{}
+	}
+	return s, nil
+}
+
+// Save writes the state back where it was loaded from, replacing the file
+// at once. Only the owner can read it.
+func (s *State) Save() error {
+	raw, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(s.path), err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".state-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	return nil
+}
+
+// Sum returns the hash of content recorded in the state.
+func Sum(content []byte) string {
+	s := sha256.Sum256(content)
+	return hex.EncodeToString(s[:])
+}
+
+// SumFile returns the Sum of the content of the file at path, reading it
+// block by block.
+func SumFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,45 @@
+package vault
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/suderio/scadufax/pkg/secrets"
+)
+
+// Provider is a secrets.Provider backed by a vault. The vault is only
+// opened, and the passphrase only requested, on the first lookup.
+type Provider struct {
+	path       string
+	passphrase func() ([]byte, error)
+
+	once  sync.Once
+	vault *Vault
+	err   error
+}
+
+// NewProvider returns a Provider for the vault at path, obtaining the
+// passphrase from passphrase when first needed.
+func NewProvider(path string, passphrase func() ([]byte, error)) *Provider {
+	return &Provider{path: path, passphrase: passphrase}
+}
+
+// Lookup implements secrets.Provider.
+func (p *Provider) Lookup(key string) (string, error) {
+	p.once.Do(func() {
+		var pass []byte
+		pass, p.err = p.passphrase()
+		if p.err == nil {
+			p.vault, p.err = Open(p.path, pass)
+		}
+	})
+	if p.err != nil {
+		return "", p.err
+	}
+
+	val, ok := p.vault.Get(key)
+	if !ok {
+		return "", fmt.Errorf("secret key %q not found in vault %s: %w", key, p.path, secrets.ErrNotFound)
+	}
+	return val, nil
+}
@@ -0,0 +1,231 @@
+package vault
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// ErrWrongPassphrase is returned when a vault cannot be decrypted, either
+// because the passphrase is wrong or the file was tampered with.
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted vault")
+
+// Default scrypt cost parameters for new vaults.
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// Bounds on the scrypt cost parameters read from a vault, so that a
+// damaged or hostile file cannot make Open exhaust memory or time.
+const (
+	maxScryptN   = 1 << 20
+	maxScryptR   = 32
+	maxScryptP   = 16
+	maxScryptMem = 1 << 30 // 128 * N * r bytes
+)
+
+// header is bound to the ciphertext as additional data.
+const header = "scadufax-vault-v1"
+
+// file is the on-disk format. Everything but the cost parameters is opaque.
+type file struct {
+	Version int    `json:"version"`
+	KDF     string `json:"kdf"`
+	N       int    `json:"n"`
+	R       int    `json:"r"`
+	P       int    `json:"p"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+// Vault is a set of secrets stored encrypted with a passphrase-derived key
+// (scrypt + XChaCha20-Poly1305). The file is always written with 0600.
+type Vault struct {
+	path       string
+	passphrase []byte
+	entries    map[string]string
+}
+
+// Open decrypts the vault at path. A missing file yields an empty vault that
+// is created on the first Save.
+func Open(path string, passphrase []byte) (*Vault, error) {
+	v := &Vault{path: path, passphrase: passphrase, entries: map[string]string{}}
+
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return v, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vault %s: %w", path, err)
+	}
+
+	var f file
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse vault %s: %w", path, err)
+	}
+	if f.Version != 1 || f.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported vault format %s v%d in %s", f.KDF, f.Version, path)
+	}
+	if err := f.check(); err != nil {
+		return nil, fmt.Errorf("invalid vault %s: %w", path, err)
+	}
+
+	key, err := scrypt.Key(passphrase, f.Salt, f.N, f.R, f.P, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive vault key: %w", err)
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := aead.Open(nil, f.Nonce, f.Data, []byte(header))
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	if err := json.Unmarshal(plain, &v.entries); err != nil {
+		return nil, fmt.Errorf("failed to decode vault %s: %w", path, err)
+	}
+	return v, nil
+}
+
+// check validates the parameters of f before they are used.
+func (f *file) check() error {
+	if f.N < 2 || f.N > maxScryptN || f.N&(f.N-1) != 0 {
+		return fmt.Errorf("scrypt N must be a power of 2 up to %d, got %d", maxScryptN, f.N)
+	}
+	if f.R < 1 || f.R > maxScryptR {
+		return fmt.Errorf("scrypt r must be between 1 and %d, got %d", maxScryptR, f.R)
+	}
+	if f.P < 1 || f.P > maxScryptP {
+		return fmt.Errorf("scrypt p must be between 1 and %d, got %d", maxScryptP, f.P)
+	}
+	if 128*f.N*f.R > maxScryptMem {
+		return fmt.Errorf("scrypt parameters N=%d r=%d need more than %d bytes", f.N, f.R, maxScryptMem)
+	}
+	if len(f.Salt) == 0 {
+		return errors.New("missing salt")
+	}
+	if len(f.Nonce) != chacha20poly1305.NonceSizeX {
+		return fmt.Errorf("nonce must be %d bytes, got %d", chacha20poly1305.NonceSizeX, len(f.Nonce))
+	}
+	return nil
+}
+
+// Path returns the location of the vault file.
+func (v *Vault) Path() string {
+	return v.path
+}
+
+// Get returns the value stored under key.
+func (v *Vault) Get(key string) (string, bool) {
+	val, ok := v.entries[key]
+	return val, ok
+}
+
+// Set stores value under key. Call Save to persist it.
+func (v *Vault) Set(key, value string) {
+	v.entries[key] = value
+}
+
+// Delete removes key, reporting whether it was present. Call Save to persist
+// the removal.
+func (v *Vault) Delete(key string) bool {
+	_, ok := v.entries[key]
+	delete(v.entries, key)
+	return ok
+}
+
+// Keys returns the stored keys, sorted.
+func (v *Vault) Keys() []string {
+	keys := make([]string, 0, len(v.entries))
+	for k := range v.entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Entries returns a copy of all stored secrets.
+func (v *Vault) Entries() map[string]string {
+	out := make(map[string]string, len(v.entries))
+	for k, val := range v.entries {
+		out[k] = val
+	}
+	return out
+}
+
+// Replace swaps all stored secrets for entries. Call Save to persist them.
+func (v *Vault) Replace(entries map[string]string) {
+	v.entries = make(map[string]string, len(entries))
+	for k, val := range entries {
+		v.entries[k] = val
+	}
+}
+
+// Save encrypts the vault with a fresh salt and nonce and atomically
+// replaces the file, which is always left with 0600 permissions.
+func (v *Vault) Save() error {
+	plain, err := json.Marshal(v.entries)
+	if err != nil {
+		return err
+	}
+
+	f := file{Version: 1, KDF: "scrypt", N: scryptN, R: scryptR, P: scryptP}
+	f.Salt = make([]byte, 16)
+	f.Nonce = make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err := rand.Read(f.Salt); err != nil {
+		return err
+	}
+	if _, err := rand.Read(f.Nonce); err != nil {
+		return err
+	}
+
+	key, err := scrypt.Key(v.passphrase, f.Salt, f.N, f.R, f.P, chacha20poly1305.KeySize)
+	if err != nil {
+		return fmt.Errorf("failed to derive vault key: %w", err)
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return err
+	}
+	f.Data = aead.Seal(nil, f.Nonce, plain, []byte(header))
+
+	raw, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(v.path), 0700); err != nil {
+		return fmt.Errorf("failed to create vault dir: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(v.path), ".vault-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), v.path); err != nil {
+		return fmt.Errorf("failed to write vault %s: %w", v.path, err)
+	}
+	return os.Chmod(v.path, 0600)
+}